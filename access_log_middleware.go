@@ -0,0 +1,216 @@
+package web
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/http"
+	"path"
+	"strconv"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Formats for AccessLogOption.Format.
+const (
+	// AccessLogCommon is Apache's Common Log Format.
+	AccessLogCommon = `{{ .RemoteHost }} - {{ dash .User }} [{{ .Time.Format "02/Jan/2006:15:04:05 -0700" }}] "{{ .Method }} {{ .URI }} {{ .Proto }}" {{ .Status }} {{ dash .Size }}`
+
+	// AccessLogCombined is Apache's Combined Log Format, which adds the referer and user agent to the Common one.
+	AccessLogCombined = AccessLogCommon + ` {{ quote .Referer }} {{ quote .UserAgent }}`
+)
+
+// AccessLogOption configures AccessLogMiddleware.
+type AccessLogOption struct {
+	// If set, requests are logged to Slog as structured records, and Format and Output are ignored. Requests
+	// that fail with a 5xx status are logged at slog.LevelError, others at slog.LevelInfo.
+	Slog *slog.Logger
+
+	// If set, Format is a text/template executed with an *AccessLogEntry for each request, such as AccessLogCommon.
+	// Templates may also call dash, which turns empty strings and zeros into "-", and quote, which quotes a string
+	// (or turns it into "-" if it's empty). The default is AccessLogCombined.
+	Format string
+
+	// If set, formatted lines are written to Output. Otherwise they're printed to Logger.
+	Output io.Writer
+
+	// If set, requests whose path matches one of ExcludePaths (eg, "/health" or "/metrics/*", as in path.Match)
+	// aren't logged.
+	ExcludePaths []string
+
+	// If set, only this fraction of requests, between 0 and 1, is logged. Requests that fail with a 5xx status are
+	// always logged.
+	SampleRate float64
+}
+
+// AccessLogEntry describes a request that AccessLogMiddleware logs.
+type AccessLogEntry struct {
+	Time       time.Time // When the request started
+	Method     string
+	URI        string // The path and query, as the client sent them
+	Path       string
+	RoutePath  string // Eg, "/users/:id"; empty if the request wasn't routed
+	Proto      string
+	Status     int
+	Size       int
+	Duration   time.Duration
+	RemoteAddr string
+	RemoteHost string // RemoteAddr without the port
+	User       string // The HTTP basic auth username, if any
+	Referer    string
+	UserAgent  string
+	RequestID  string // Set by RequestIDMiddleware
+}
+
+// AccessLogMiddleware returns a middleware that logs every request, after it's served, in a configurable format.
+// It's a more complete alternative to LoggerMiddleware. Add it before other middleware so that it times them too.
+func AccessLogMiddleware(options ...AccessLogOption) func(ResponseWriter, *Request, NextMiddlewareFunc) {
+	var option AccessLogOption
+	if len(options) > 0 {
+		option = options[0]
+	}
+	if option.Format == "" {
+		option.Format = AccessLogCombined
+	}
+	l := &accessLogger{
+		option: option,
+		tpl:    template.Must(template.New("AccessLog").Funcs(accessLogFuncs).Parse(option.Format)),
+	}
+	return l.serve
+}
+
+var accessLogFuncs = template.FuncMap{
+	"dash": func(v interface{}) interface{} {
+		switch v {
+		case "", 0, int64(0):
+			return "-"
+		}
+		return v
+	},
+	"quote": func(s string) string {
+		if s == "" {
+			return `"-"`
+		}
+		return strconv.Quote(s)
+	},
+}
+
+type accessLogger struct {
+	option AccessLogOption
+	tpl    *template.Template
+	mu     sync.Mutex // Serializes writes to option.Output
+}
+
+func (l *accessLogger) serve(rw ResponseWriter, req *Request, next NextMiddlewareFunc) {
+	for _, pattern := range l.option.ExcludePaths {
+		if matched, _ := path.Match(pattern, req.URL.Path); matched {
+			next(rw, req)
+			return
+		}
+	}
+
+	start := time.Now()
+	panicked := true
+	defer func() {
+		if panicked && !rw.Written() {
+			// Log the response once the router's error handling has sent it.
+			req.afterPanic(func(err interface{}) { l.log(rw, req, start, responseStatus(rw, true, err)) })
+			return
+		}
+		l.log(rw, req, start, responseStatus(rw, false, nil))
+	}()
+	next(rw, req)
+	panicked = false
+}
+
+// responseStatus returns the status of rw's response. If nothing was written to rw because of a panic with err, it's
+// the status the router answers err with when there's no Error handler.
+func responseStatus(rw ResponseWriter, panicked bool, err interface{}) int {
+	if rw.Written() {
+		return rw.StatusCode()
+	}
+	if panicked {
+		return panicStatus(err)
+	}
+	return http.StatusOK
+}
+
+func (l *accessLogger) log(rw ResponseWriter, req *Request, start time.Time, status int) {
+	if l.option.SampleRate > 0 && status < http.StatusInternalServerError && rand.Float64() >= l.option.SampleRate {
+		return
+	}
+
+	entry := &AccessLogEntry{
+		Time:       start,
+		Method:     req.Method,
+		URI:        req.RequestURI,
+		Path:       req.URL.Path,
+		RoutePath:  req.RoutePath(),
+		Proto:      req.Proto,
+		Status:     status,
+		Size:       rw.Size(),
+		Duration:   time.Since(start),
+		RemoteAddr: req.RemoteAddr,
+		RemoteHost: req.RemoteAddr,
+		Referer:    req.Referer(),
+		UserAgent:  req.UserAgent(),
+		RequestID:  req.RequestID(),
+	}
+	if entry.URI == "" {
+		entry.URI = req.URL.RequestURI()
+	}
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		entry.RemoteHost = host
+	}
+	entry.User, _, _ = req.BasicAuth()
+
+	if l.option.Slog != nil {
+		l.logSlog(req, entry)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := l.tpl.Execute(&buf, entry); err != nil {
+		buf.Reset()
+		buf.WriteString("access log: " + err.Error())
+	}
+	if l.option.Output == nil {
+		Logger.Println(buf.String())
+		return
+	}
+	buf.WriteByte('\n')
+	l.mu.Lock()
+	l.option.Output.Write(buf.Bytes())
+	l.mu.Unlock()
+}
+
+func (l *accessLogger) logSlog(req *Request, entry *AccessLogEntry) {
+	level := slog.LevelInfo
+	if entry.Status >= http.StatusInternalServerError {
+		level = slog.LevelError
+	}
+	attrs := []slog.Attr{
+		slog.String("method", entry.Method),
+		slog.String("path", entry.Path),
+		slog.String("route", entry.RoutePath),
+		slog.String("query", req.URL.RawQuery),
+		slog.Int("status", entry.Status),
+		slog.Int("size", entry.Size),
+		slog.Duration("duration", entry.Duration),
+		slog.String("remote_addr", entry.RemoteAddr),
+		slog.String("user_agent", entry.UserAgent),
+	}
+	if entry.Referer != "" {
+		attrs = append(attrs, slog.String("referer", entry.Referer))
+	}
+	if entry.User != "" {
+		attrs = append(attrs, slog.String("user", entry.User))
+	}
+	if entry.RequestID != "" {
+		attrs = append(attrs, slog.String("request_id", entry.RequestID))
+	}
+	l.option.Slog.LogAttrs(req.Context(), level, "request", attrs...)
+}
@@ -0,0 +1,150 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"log"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func newAccessLogRouter(option AccessLogOption) *Router {
+	router := New(Context{})
+	router.Middleware(RequestIDMiddleware())
+	router.Middleware(AccessLogMiddleware(option))
+	router.Get("/users/:id", (*Context).A)
+	router.Get("/health", (*Context).A)
+	router.Get("/boom", (*Context).ErrorAction)
+	router.Get("/invalid", func(rw ResponseWriter, req *Request) { panic(&BindError{}) })
+	return router
+}
+
+func TestAccessLogFormats(t *testing.T) {
+	var buf bytes.Buffer
+	router := newAccessLogRouter(AccessLogOption{Output: &buf})
+
+	rw, req := newTestRequest("GET", "/users/3?tab=posts")
+	req.RemoteAddr = "10.0.0.1:5555"
+	req.Header.Set("User-Agent", `curl "8"`)
+	req.SetBasicAuth("bob", "secret")
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "context-A", 200)
+	assert.Regexp(t, `^10\.0\.0\.1 - bob \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [-+]\d{4}\] "GET /users/3\?tab=posts HTTP/1\.1" 200 9 "-" "curl \\"8\\""\n$`, buf.String())
+
+	buf.Reset()
+	router = newAccessLogRouter(AccessLogOption{Output: &buf, Format: AccessLogCommon})
+	rw, req = newTestRequest("GET", "/missing")
+	req.RemoteAddr = "10.0.0.1:5555"
+	router.ServeHTTP(rw, req)
+	assert.Regexp(t, `^10\.0\.0\.1 - - \[.*\] "GET /missing HTTP/1\.1" 404 \d+\n$`, buf.String())
+
+	buf.Reset()
+	router = newAccessLogRouter(AccessLogOption{Output: &buf, Format: "{{ .Method }} {{ .RoutePath }} {{ .Status }} {{ .RequestID }}"})
+	rw, req = newTestRequest("GET", "/users/3")
+	req.Header.Set("X-Request-ID", "abc")
+	router.ServeHTTP(rw, req)
+	assert.Equal(t, "GET /users/:id 200 abc\n", buf.String())
+
+	// Lines go to Logger by default:
+	oldLogger := Logger
+	Logger = log.New(&buf, "", 0)
+	defer func() { Logger = oldLogger }()
+	buf.Reset()
+	router = newAccessLogRouter(AccessLogOption{Format: "{{ .Path }}"})
+	rw, req = newTestRequest("GET", "/users/3")
+	router.ServeHTTP(rw, req)
+	assert.Equal(t, "/users/3\n", buf.String())
+}
+
+func TestAccessLogSlog(t *testing.T) {
+	var buf bytes.Buffer
+	router := newAccessLogRouter(AccessLogOption{Slog: slog.New(slog.NewJSONHandler(&buf, nil))})
+
+	rw, req := newTestRequest("GET", "/users/3?tab=posts")
+	req.Header.Set("X-Request-ID", "abc")
+	req.Header.Set("User-Agent", "curl")
+	router.ServeHTTP(rw, req)
+
+	var record map[string]interface{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+	assert.Equal(t, "INFO", record["level"])
+	assert.Equal(t, "request", record["msg"])
+	assert.Equal(t, "GET", record["method"])
+	assert.Equal(t, "/users/3", record["path"])
+	assert.Equal(t, "/users/:id", record["route"])
+	assert.Equal(t, "tab=posts", record["query"])
+	assert.Equal(t, float64(200), record["status"])
+	assert.Equal(t, float64(9), record["size"])
+	assert.Equal(t, "curl", record["user_agent"])
+	assert.Equal(t, "abc", record["request_id"])
+	assert.Contains(t, record, "duration")
+
+	buf.Reset()
+	rw, req = newTestRequest("GET", "/boom")
+	router.ServeHTTP(rw, req)
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+	assert.Equal(t, "ERROR", record["level"])
+	assert.Equal(t, float64(500), record["status"])
+
+	// Panics with a StatusCoder are logged with the status they're answered with:
+	buf.Reset()
+	rw, req = newTestRequest("GET", "/invalid")
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "web: couldn't bind request:", 422)
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+	assert.Equal(t, "INFO", record["level"])
+	assert.Equal(t, float64(422), record["status"])
+}
+
+func TestAccessLogPanicsRecoveredByMiddleware(t *testing.T) {
+	var buf bytes.Buffer
+	router := New(Context{})
+	router.Middleware(ShowErrorsMiddleware)
+	router.Middleware(AccessLogMiddleware(AccessLogOption{Output: &buf, Format: "{{ .Path }} {{ .Status }}"}))
+	router.Get("/boom", (*Context).ErrorAction)
+
+	rw, req := newTestRequest("GET", "/boom")
+	router.ServeHTTP(rw, req)
+	assert.Equal(t, 500, rw.Code)
+	assert.Equal(t, "/boom 500\n", buf.String())
+
+	// Middleware that doesn't call PanicRecovered still gets the panic logged, once the request is done:
+	buf.Reset()
+	router = New(Context{})
+	router.Middleware(func(rw ResponseWriter, req *Request, next NextMiddlewareFunc) {
+		defer func() {
+			if recover() != nil {
+				rw.WriteHeader(503)
+			}
+		}()
+		next(rw, req)
+	})
+	router.Middleware(AccessLogMiddleware(AccessLogOption{Output: &buf, Format: "{{ .Path }} {{ .Status }}"}))
+	router.Get("/boom", (*Context).ErrorAction)
+
+	rw, req = newTestRequest("GET", "/boom")
+	router.ServeHTTP(rw, req)
+	assert.Equal(t, 503, rw.Code)
+	assert.Equal(t, "/boom 503\n", buf.String())
+}
+
+func TestAccessLogExcludeAndSample(t *testing.T) {
+	var buf bytes.Buffer
+	router := newAccessLogRouter(AccessLogOption{
+		Output:       &buf,
+		Format:       "{{ .Path }}",
+		ExcludePaths: []string{"/health", "/users/*"},
+		SampleRate:   0.000001,
+	})
+
+	for i := 0; i < 10; i++ {
+		for _, path := range []string{"/health", "/users/3", "/missing", "/boom", "/invalid"} {
+			rw, req := newTestRequest("GET", path)
+			router.ServeHTTP(rw, req)
+		}
+	}
+	// Excluded paths are never logged, and sampling keeps errors:
+	assert.Equal(t, strings.Repeat("/boom\n", 10), buf.String())
+}
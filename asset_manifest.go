@@ -0,0 +1,143 @@
+package web
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// AssetCacheControl is the Cache-Control header sent with fingerprinted assets. Their url changes whenever
+// their content does, so clients can cache them forever.
+var AssetCacheControl = "public, max-age=31536000, immutable"
+
+// DefaultAssetManifest is the manifest that AssetPath looks names up in. Set it once your manifest is built.
+var DefaultAssetManifest *AssetManifest
+
+// AssetPath returns the fingerprinted url of the asset name (eg, "app.js" becomes "/assets/app.3f9a1c2e.js")
+// according to DefaultAssetManifest. If there's no manifest, or it doesn't know name, the unhashed url is returned.
+// TemplateRenderer templates can call it as {{ asset "app.js" }}.
+func AssetPath(name string) string {
+	if DefaultAssetManifest == nil {
+		return path.Join("/", name)
+	}
+	return DefaultAssetManifest.Path(name)
+}
+
+// AssetManifest maps the names of static files to fingerprinted names that include a hash of their content,
+// so that they can be served with far-future caching headers. It's built once, when it's created, so the files
+// shouldn't change while it's in use.
+type AssetManifest struct {
+	prefix       string
+	dir          http.FileSystem
+	fingerprints map[string]string // "app.js" => "app.3f9a1c2e.js"
+	originals    map[string]string // "/app.3f9a1c2e.js" => "/app.js"
+}
+
+// NewAssetManifestFromDir hashes every file in dir and returns a manifest that serves them under the url path
+// prefix (eg, "/assets").
+func NewAssetManifestFromDir(dir http.FileSystem, prefix string) (*AssetManifest, error) {
+	m := &AssetManifest{
+		prefix:       strings.TrimSuffix(prefix, "/"),
+		dir:          dir,
+		fingerprints: make(map[string]string),
+		originals:    make(map[string]string),
+	}
+	if err := m.walk("/"); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// NewAssetManifestFromFS is like NewAssetManifestFromDir, but hashes the files of an fs.FS, such as an embed.FS.
+func NewAssetManifestFromFS(fsys fs.FS, prefix string) (*AssetManifest, error) {
+	return NewAssetManifestFromDir(http.FS(fsys), prefix)
+}
+
+func (m *AssetManifest) walk(dir string) error {
+	d, err := m.dir.Open(dir)
+	if err != nil {
+		return err
+	}
+	infos, err := d.Readdir(-1)
+	d.Close()
+	if err != nil {
+		return err
+	}
+
+	for _, fi := range infos {
+		name := path.Join(dir, fi.Name())
+		if fi.IsDir() {
+			if err := m.walk(name); err != nil {
+				return err
+			}
+			continue
+		}
+
+		f, err := m.dir.Open(name)
+		if err != nil {
+			return err
+		}
+		h := sha256.New()
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+
+		ext := path.Ext(name)
+		fingerprinted := name[:len(name)-len(ext)] + "." + hex.EncodeToString(h.Sum(nil)[:4]) + ext
+		m.fingerprints[name[1:]] = fingerprinted[1:]
+		m.originals[fingerprinted] = name
+	}
+	return nil
+}
+
+// Path returns the fingerprinted url of name, which is relative to the root of the manifest's files.
+// If name isn't in the manifest, its unhashed url is returned.
+func (m *AssetManifest) Path(name string) string {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if fingerprinted, ok := m.fingerprints[name]; ok {
+		name = fingerprinted
+	}
+	return m.prefix + "/" + name
+}
+
+// Manifest returns a copy of the mapping from file names to fingerprinted file names, eg for writing out as JSON
+// for other tools.
+func (m *AssetManifest) Manifest() map[string]string {
+	manifest := make(map[string]string, len(m.fingerprints))
+	for name, fingerprinted := range m.fingerprints {
+		manifest[name] = fingerprinted
+	}
+	return manifest
+}
+
+// Middleware returns a static middleware that serves the manifest's files under its prefix. Fingerprinted urls
+// are served with AssetCacheControl and an Expires a year away. Unhashed urls still work, for compatibility,
+// but are cached according to option.CacheRules like any other static file. option.Prefix is ignored.
+func (m *AssetManifest) Middleware(options ...StaticOption) func(ResponseWriter, *Request, NextMiddlewareFunc) {
+	var option StaticOption
+	if len(options) > 0 {
+		option = options[0]
+	}
+	option.Prefix = m.prefix
+	s := &staticServer{dir: m.dir, option: option, assets: m}
+	return s.serve
+}
+
+// resolve returns the unhashed name of file if it's a fingerprinted name in the manifest.
+func (m *AssetManifest) resolve(file string) (string, bool) {
+	original, ok := m.originals[path.Clean("/"+file)]
+	return original, ok
+}
+
+// setAssetCacheHeaders sets the caching headers of a fingerprinted asset.
+func setAssetCacheHeaders(w ResponseWriter) {
+	w.Header().Set("Cache-Control", AssetCacheControl)
+	w.Header().Set("Expires", time.Now().AddDate(1, 0, 0).UTC().Format(http.TimeFormat))
+}
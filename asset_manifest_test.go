@@ -0,0 +1,84 @@
+package web
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func newTestAssetManifest(t *testing.T) *AssetManifest {
+	m, err := NewAssetManifestFromFS(fstest.MapFS{
+		"app.js":         {Data: []byte("alert(1)")},
+		"css/site.css":   {Data: []byte("body {}")},
+		"images/logo":    {Data: []byte("logo")},
+		"images/new.png": {Data: []byte("png")},
+	}, "/assets/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestAssetManifestPath(t *testing.T) {
+	m := newTestAssetManifest(t)
+
+	assert.Regexp(t, `^/assets/app\.[0-9a-f]{8}\.js$`, m.Path("app.js"))
+	assert.Regexp(t, `^/assets/css/site\.[0-9a-f]{8}\.css$`, m.Path("/css/site.css"))
+	assert.Regexp(t, `^/assets/images/logo\.[0-9a-f]{8}$`, m.Path("images/logo"))
+	assert.Equal(t, "/assets/missing.js", m.Path("missing.js"))
+	assert.Equal(t, 4, len(m.Manifest()))
+	assert.Equal(t, m.Path("app.js"), "/assets/"+m.Manifest()["app.js"])
+
+	// The fingerprint only depends on the content:
+	other, err := NewAssetManifestFromFS(fstest.MapFS{"app.js": {Data: []byte("alert(1)")}}, "/assets")
+	assert.NoError(t, err)
+	assert.Equal(t, m.Path("app.js"), other.Path("app.js"))
+	other, err = NewAssetManifestFromFS(fstest.MapFS{"app.js": {Data: []byte("alert(2)")}}, "/assets")
+	assert.NoError(t, err)
+	assert.NotEqual(t, m.Path("app.js"), other.Path("app.js"))
+
+	// AssetPath uses the default manifest, and is available to templates:
+	assert.Equal(t, "/app.js", AssetPath("app.js"))
+	DefaultAssetManifest = m
+	defer func() { DefaultAssetManifest = nil }()
+	assert.Equal(t, m.Path("app.js"), AssetPath("app.js"))
+
+	renderer := NewTemplateRenderer(fstest.MapFS{"page.html": {Data: []byte(`<script src="{{ asset "app.js" }}"></script>`)}}, TemplateOptions{})
+	rw, _ := newTestRequest("GET", "/")
+	renderer.Render(&appResponseWriter{ResponseWriter: rw}, http.StatusOK, "page", nil)
+	assert.Equal(t, `<script src="`+m.Path("app.js")+`"></script>`, rw.Body.String())
+}
+
+func TestAssetManifestMiddleware(t *testing.T) {
+	m := newTestAssetManifest(t)
+	router := New(Context{})
+	router.Middleware(m.Middleware(StaticOption{CacheRules: []StaticCacheRule{{Pattern: "*", CacheControl: "no-cache"}}}))
+
+	rw, req := newTestRequest("GET", m.Path("css/site.css"))
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "body {}", 200)
+	assert.Equal(t, "text/css; charset=utf-8", rw.Header().Get("Content-Type"))
+	assert.Equal(t, "public, max-age=31536000, immutable", rw.Header().Get("Cache-Control"))
+	expires, err := http.ParseTime(rw.Header().Get("Expires"))
+	assert.NoError(t, err)
+	assert.True(t, expires.After(time.Now().AddDate(0, 11, 0)))
+
+	// Unhashed names still work:
+	rw, req = newTestRequest("GET", "/assets/css/site.css")
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "body {}", 200)
+	assert.Equal(t, "no-cache", rw.Header().Get("Cache-Control"))
+
+	rw, req = newTestRequest("GET", "/app.js")
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "Not Found", 404)
+
+	// A stale fingerprint isn't served:
+	stale := bytes.Replace([]byte(m.Path("app.js")), []byte(".js"), []byte("0.js"), 1)
+	rw, req = newTestRequest("GET", string(stale))
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "Not Found", 404)
+}
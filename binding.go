@@ -0,0 +1,385 @@
+package web
+
+import (
+	"encoding"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMaxMemory is the maximum number of bytes of a multipart form that Bind will hold in memory.
+// The remainder is stored on disk in temporary files.
+var DefaultMaxMemory int64 = 32 << 20
+
+// These are the sources a field can be bound from. They're also the struct tag names that select them.
+const (
+	BindSourcePath   = "path"
+	BindSourceQuery  = "query"
+	BindSourceHeader = "header"
+	BindSourceForm   = "form"
+	BindSourceBody   = "body"
+)
+
+// FieldError describes a single value that couldn't be bound to a struct field.
+type FieldError struct {
+	// Field is the dotted path of the struct field, eg "Page" or "Filter.Since".
+	Field string
+	// Source is where the value came from (path, query, header, form or body).
+	Source string
+	// Key is the name of the value in its source, eg the query parameter name.
+	Key string
+	// Value is the raw value that failed to convert.
+	Value string
+	// Err is the conversion error.
+	Err error
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s %q: invalid value %q: %v", e.Source, e.Key, e.Value, e.Err)
+}
+
+// BindError is returned by Bind when the request couldn't be bound. If the body itself was malformed,
+// Err is set. Otherwise Fields lists each value that couldn't be converted.
+type BindError struct {
+	Err    error
+	Fields []*FieldError
+}
+
+func (e *BindError) Error() string {
+	if e.Err != nil {
+		return "web: couldn't decode request body: " + e.Err.Error()
+	}
+	msgs := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		msgs[i] = f.Error()
+	}
+	return "web: couldn't bind request: " + strings.Join(msgs, "; ")
+}
+
+// StatusCode is the HTTP status the error should be rendered with: 400 if the body couldn't be decoded,
+// 415 if its Content-Type isn't supported, and 422 if individual fields had bad values.
+func (e *BindError) StatusCode() int {
+	if e.Err == errUnsupportedContentType {
+		return http.StatusUnsupportedMediaType
+	}
+	if e.Err != nil {
+		return http.StatusBadRequest
+	}
+	return http.StatusUnprocessableEntity
+}
+
+var errUnsupportedContentType = fmt.Errorf("unsupported Content-Type")
+
+// Bind populates the struct pointed to by dst from the request. Fields are selected with struct tags:
+//
+//	type Params struct {
+//		ID     int       `path:"id"`
+//		Page   int       `query:"page" default:"1"`
+//		Tenant string    `header:"X-Tenant"`
+//		Email  string    `form:"email"`
+//		Name   string    `json:"name"`
+//	}
+//
+// The body is decoded according to its Content-Type: JSON and XML bodies are decoded with encoding/json and
+// encoding/xml, into the fields without a path, query, header or form tag only, and url-encoded and multipart
+// bodies populate form fields. Path, query and header values are then applied on top. Fields whose value is
+// missing from every source get their default tag, if any.
+//
+// Values are converted to strings, bools, ints, uints, floats, time.Duration, time.Time (RFC 3339),
+// encoding.TextUnmarshaler, pointers to those, or slices of those (from repeated values).
+// If anything fails, the returned error is a *BindError.
+func (r *Request) Bind(dst interface{}) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		panic("web: Bind needs a non-nil pointer to a struct")
+	}
+	v = v.Elem()
+	fields := cachedBindFields(v.Type())
+
+	for _, f := range fields {
+		// This also allocates the embedded pointers leading to f, so their fields can be read after Bind.
+		field := fieldByIndex(v, f.index)
+		if f.hasDefault {
+			if err := setField(field, []string{f.defaultValue}); err != nil {
+				panic("web: invalid default for field " + f.name + ": " + err.Error())
+			}
+		}
+	}
+
+	var form map[string][]string
+	if r.hasBody() {
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		var err error
+		switch mediaType {
+		case "application/json", "":
+			err = decodeBody(v, fields, json.NewDecoder(r.Body).Decode)
+		case "application/xml", "text/xml":
+			err = decodeBody(v, fields, xml.NewDecoder(r.Body).Decode)
+		case "application/x-www-form-urlencoded":
+			if err = r.ParseForm(); err == nil {
+				form = r.PostForm
+			}
+		case "multipart/form-data":
+			if err = r.ParseMultipartForm(DefaultMaxMemory); err == nil {
+				form = r.MultipartForm.Value
+			}
+		default:
+			err = errUnsupportedContentType
+		}
+		if err != nil && err != io.EOF {
+			return &BindError{Err: err}
+		}
+	}
+
+	var query map[string][]string
+	var bindErr *BindError
+	for _, f := range fields {
+		var values []string
+		switch f.source {
+		case BindSourcePath:
+			if val, ok := r.PathParams[f.key]; ok {
+				values = []string{val}
+			}
+		case BindSourceQuery:
+			if query == nil {
+				query = r.URL.Query()
+			}
+			values = query[f.key]
+		case BindSourceHeader:
+			values = r.Header[http.CanonicalHeaderKey(f.key)]
+		case BindSourceForm:
+			values = form[f.key]
+		default:
+			continue
+		}
+		if len(values) == 0 {
+			continue
+		}
+		if err := setField(fieldByIndex(v, f.index), values); err != nil {
+			if bindErr == nil {
+				bindErr = &BindError{}
+			}
+			bindErr.Fields = append(bindErr.Fields, &FieldError{Field: f.name, Source: f.source, Key: f.key, Value: strings.Join(values, ","), Err: err})
+		}
+	}
+
+	if bindErr != nil {
+		return bindErr
+	}
+	return nil
+}
+
+// decodeBody decodes a JSON or XML body into v. It decodes into a copy of v whose path, query, header and form
+// fields are zero, and then puts those fields back, so that the body can't set them by their Go names.
+func decodeBody(v reflect.Value, fields []*bindField, decode func(interface{}) error) error {
+	shadow := reflect.New(v.Type()).Elem()
+	shadow.Set(v)
+	for _, f := range fields {
+		if f.source != BindSourceBody {
+			field := unsharedFieldByIndex(shadow, f.index)
+			field.Set(reflect.Zero(field.Type()))
+		}
+	}
+	if err := decode(shadow.Addr().Interface()); err != nil {
+		return err
+	}
+	for _, f := range fields {
+		if f.source != BindSourceBody {
+			fieldByIndex(shadow, f.index).Set(fieldByIndex(v, f.index))
+		}
+	}
+	v.Set(shadow)
+	return nil
+}
+
+func (r *Request) hasBody() bool {
+	return r.Body != nil && r.Body != http.NoBody && r.ContentLength != 0
+}
+
+type bindField struct {
+	index        []int
+	name         string
+	source       string
+	key          string
+	hasDefault   bool
+	defaultValue string
+}
+
+var bindFieldCache sync.Map // map[reflect.Type][]*bindField
+
+var bindSources = []string{BindSourcePath, BindSourceQuery, BindSourceHeader, BindSourceForm}
+
+func cachedBindFields(t reflect.Type) []*bindField {
+	if fields, ok := bindFieldCache.Load(t); ok {
+		return fields.([]*bindField)
+	}
+	fields := collectBindFields(t, nil, "")
+	bindFieldCache.Store(t, fields)
+	return fields
+}
+
+func collectBindFields(t reflect.Type, index []int, prefix string) []*bindField {
+	var fields []*bindField
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" && !sf.Anonymous {
+			continue
+		}
+		fieldIndex := append(append([]int{}, index...), i)
+		name := prefix + sf.Name
+
+		f := &bindField{index: fieldIndex, name: name, source: BindSourceBody}
+		for _, source := range bindSources {
+			if key, ok := sf.Tag.Lookup(source); ok {
+				f.source = source
+				f.key = key
+				break
+			}
+		}
+		f.defaultValue, f.hasDefault = sf.Tag.Lookup("default")
+
+		if f.source == BindSourceBody && !f.hasDefault {
+			// Untagged structs are walked so that embedded and grouped params can be bound. Embedded pointers to
+			// structs are walked too, and allocated by Bind; they must be exported for Bind to set them.
+			ft := sf.Type
+			if sf.Anonymous && sf.PkgPath == "" && ft.Kind() == reflect.Ptr && ft.Elem().Kind() == reflect.Struct {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct && !isBindScalar(ft) {
+				if sf.Anonymous {
+					fields = append(fields, collectBindFields(ft, fieldIndex, prefix)...)
+				} else {
+					fields = append(fields, collectBindFields(ft, fieldIndex, name+".")...)
+				}
+			}
+			continue
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+// fieldByIndex is like reflect.Value.FieldByIndex but allocates nil embedded pointers along the way.
+func fieldByIndex(v reflect.Value, index []int) reflect.Value {
+	for _, i := range index {
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(i)
+	}
+	return v
+}
+
+// unsharedFieldByIndex is like fieldByIndex, but first points the embedded pointers along the way at copies of
+// their structs, so that setting the field doesn't change the value v was copied from.
+func unsharedFieldByIndex(v reflect.Value, index []int) reflect.Value {
+	for _, i := range index {
+		if v.Kind() == reflect.Ptr {
+			c := reflect.New(v.Type().Elem())
+			if !v.IsNil() {
+				c.Elem().Set(v.Elem())
+			}
+			v.Set(c)
+			v = c.Elem()
+		}
+		v = v.Field(i)
+	}
+	return v
+}
+
+var (
+	timeType            = reflect.TypeOf(time.Time{})
+	durationType        = reflect.TypeOf(time.Duration(0))
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+func isBindScalar(t reflect.Type) bool {
+	return t == timeType || reflect.PtrTo(t).Implements(textUnmarshalerType)
+}
+
+func setField(field reflect.Value, values []string) error {
+	if field.Kind() == reflect.Slice && field.Type().Elem().Kind() != reflect.Uint8 && !isBindScalar(field.Type()) {
+		slice := reflect.MakeSlice(field.Type(), len(values), len(values))
+		for i, val := range values {
+			if err := setValue(slice.Index(i), val); err != nil {
+				return err
+			}
+		}
+		field.Set(slice)
+		return nil
+	}
+	return setValue(field, values[0])
+}
+
+func setValue(v reflect.Value, s string) error {
+	if v.Kind() == reflect.Ptr {
+		ptr := reflect.New(v.Type().Elem())
+		if err := setValue(ptr.Elem(), s); err != nil {
+			return err
+		}
+		v.Set(ptr)
+		return nil
+	}
+
+	if v.CanAddr() && v.Addr().Type().Implements(textUnmarshalerType) {
+		return v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+	}
+
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			v.SetBytes([]byte(s))
+			return nil
+		}
+		fallthrough
+	default:
+		return fmt.Errorf("unsupported field type %s", v.Type())
+	}
+	return nil
+}
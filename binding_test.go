@@ -0,0 +1,212 @@
+package web
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type bindPaging struct {
+	Page    int `query:"page" default:"1"`
+	PerPage int `query:"per_page" default:"25"`
+}
+
+type bindParams struct {
+	bindPaging
+	ID      int64         `path:"id"`
+	Tenant  string        `header:"X-Tenant"`
+	Tags    []string      `query:"tag"`
+	Since   *time.Time    `query:"since"`
+	Timeout time.Duration `query:"timeout"`
+	Name    string        `json:"name"`
+	Admin   bool          `json:"admin"`
+}
+
+// BindPaging is exported so that it can be embedded by pointer.
+type BindPaging struct {
+	Page    int    `query:"page" default:"1"`
+	PerPage int    `query:"per_page" default:"25"`
+	Tenant  string `header:"X-Tenant"`
+}
+
+type bindPointerParams struct {
+	*BindPaging
+	Name string `json:"name"`
+}
+
+type bindForm struct {
+	Email string `form:"email"`
+	Age   uint8  `form:"age"`
+}
+
+func TestBindPathQueryHeaderJSON(t *testing.T) {
+	var params bindParams
+	router := New(Context{})
+	router.Post("/users/:id", func(rw ResponseWriter, req *Request) {
+		if err := req.Bind(&params); err != nil {
+			panic(err)
+		}
+	})
+
+	body := strings.NewReader(`{"name": "Alice", "admin": true}`)
+	req, _ := http.NewRequest("POST", "/users/42?tag=a&tag=b&since=2015-01-02T03:04:05Z&timeout=3s&per_page=10", body)
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("X-Tenant", "acme")
+	rw := httptest.NewRecorder()
+	router.ServeHTTP(rw, req)
+
+	assert.Equal(t, 200, rw.Code)
+	assert.Equal(t, int64(42), params.ID)
+	assert.Equal(t, "acme", params.Tenant)
+	assert.Equal(t, []string{"a", "b"}, params.Tags)
+	assert.Equal(t, 2015, params.Since.Year())
+	assert.Equal(t, 3*time.Second, params.Timeout)
+	assert.Equal(t, "Alice", params.Name)
+	assert.True(t, params.Admin)
+	assert.Equal(t, 1, params.Page)
+	assert.Equal(t, 10, params.PerPage)
+}
+
+func TestBindBodyCantSetOtherSources(t *testing.T) {
+	var params bindParams
+	router := New(Context{})
+	router.Post("/users", func(rw ResponseWriter, req *Request) {
+		if err := req.Bind(&params); err != nil {
+			panic(err)
+		}
+	})
+
+	// Fields from the path, query and headers can't be set from the body by their Go names, even when their values
+	// are missing:
+	body := strings.NewReader(`{"name": "Mallory", "Tenant": "victim", "ID": 1, "Page": 9, "Tags": ["x"]}`)
+	req, _ := http.NewRequest("POST", "/users", body)
+	req.Header.Set("Content-Type", "application/json")
+	rw := httptest.NewRecorder()
+	router.ServeHTTP(rw, req)
+
+	assert.Equal(t, 200, rw.Code)
+	assert.Equal(t, "Mallory", params.Name)
+	assert.Equal(t, "", params.Tenant)
+	assert.Equal(t, int64(0), params.ID)
+	assert.Equal(t, 1, params.Page)
+	assert.Nil(t, params.Tags)
+}
+
+func TestBindEmbeddedPointer(t *testing.T) {
+	var params bindPointerParams
+	router := New(Context{})
+	router.Post("/users", func(rw ResponseWriter, req *Request) {
+		if err := req.Bind(&params); err != nil {
+			panic(err)
+		}
+	})
+
+	body := strings.NewReader(`{"name": "Mallory", "Tenant": "victim", "Page": 9}`)
+	req, _ := http.NewRequest("POST", "/users?per_page=10", body)
+	req.Header.Set("Content-Type", "application/json")
+	rw := httptest.NewRecorder()
+	router.ServeHTTP(rw, req)
+
+	assert.Equal(t, 200, rw.Code)
+	if assert.NotNil(t, params.BindPaging) {
+		assert.Equal(t, "Mallory", params.Name)
+		assert.Equal(t, "", params.Tenant)
+		assert.Equal(t, 1, params.Page)
+		assert.Equal(t, 10, params.PerPage)
+	}
+}
+
+func TestBindForm(t *testing.T) {
+	var form bindForm
+	router := New(Context{})
+	router.Post("/signup", func(rw ResponseWriter, req *Request) {
+		if err := req.Bind(&form); err != nil {
+			panic(err)
+		}
+	})
+
+	req, _ := http.NewRequest("POST", "/signup", strings.NewReader("email=a%40example.com&age=30"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rw := httptest.NewRecorder()
+	router.ServeHTTP(rw, req)
+
+	assert.Equal(t, 200, rw.Code)
+	assert.Equal(t, "a@example.com", form.Email)
+	assert.Equal(t, uint8(30), form.Age)
+}
+
+func TestBindFieldErrors(t *testing.T) {
+	router := New(Context{})
+	router.Get("/users/:id", func(rw ResponseWriter, req *Request) {
+		var params bindParams
+		err := req.Bind(&params)
+		bindErr, ok := err.(*BindError)
+		if assert.True(t, ok) {
+			assert.Equal(t, http.StatusUnprocessableEntity, bindErr.StatusCode())
+			if assert.Len(t, bindErr.Fields, 2) {
+				assert.Equal(t, "Page", bindErr.Fields[0].Field)
+				assert.Equal(t, "page", bindErr.Fields[0].Key)
+				assert.Equal(t, "two", bindErr.Fields[0].Value)
+				assert.Equal(t, "ID", bindErr.Fields[1].Field)
+				assert.Equal(t, "path", bindErr.Fields[1].Source)
+			}
+		}
+		panic(err)
+	})
+
+	rw, req := newTestRequest("GET", "/users/abc?page=two")
+	router.ServeHTTP(rw, req)
+	assert.Equal(t, http.StatusUnprocessableEntity, rw.Code)
+	assert.Contains(t, rw.Body.String(), `query "page": invalid value "two"`)
+}
+
+func TestBindMalformedBody(t *testing.T) {
+	router := New(Context{})
+	router.Post("/users/:id", func(rw ResponseWriter, req *Request) {
+		var params bindParams
+		if err := req.Bind(&params); err != nil {
+			panic(err)
+		}
+	})
+
+	for _, tc := range []struct {
+		contentType string
+		code        int
+	}{
+		{"application/json", http.StatusBadRequest},
+		{"application/octet-stream", http.StatusUnsupportedMediaType},
+	} {
+		req, _ := http.NewRequest("POST", "/users/1", bytes.NewBufferString(`{"name": `))
+		req.Header.Set("Content-Type", tc.contentType)
+		rw := httptest.NewRecorder()
+		router.ServeHTTP(rw, req)
+		assert.Equal(t, tc.code, rw.Code, tc.contentType)
+	}
+}
+
+func TestBindErrorWithErrorHandler(t *testing.T) {
+	router := New(Context{})
+	router.Error(func(rw ResponseWriter, req *Request, err interface{}) {
+		if sc, ok := err.(StatusCoder); ok {
+			rw.WriteHeader(sc.StatusCode())
+			fmt.Fprint(rw, "custom")
+			return
+		}
+		rw.WriteHeader(http.StatusInternalServerError)
+	})
+	router.Get("/users/:id", func(rw ResponseWriter, req *Request) {
+		var params bindParams
+		if err := req.Bind(&params); err != nil {
+			panic(err)
+		}
+	})
+
+	rw, req := newTestRequest("GET", "/users/abc")
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "custom", http.StatusUnprocessableEntity)
+}
@@ -0,0 +1,176 @@
+package web
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// DefaultBufferLimit is how many bytes of body a BufferedResponseWriter holds when no limit is given.
+var DefaultBufferLimit = 1 << 20
+
+// BufferedResponseWriter holds the status, headers and body written to it instead of sending them, so that
+// middleware can inspect and change them after the handler returns. Nothing is sent until Commit is called.
+//
+// If the body grows past the limit, or the handler calls Flush, the response is committed early and the rest
+// of it streams straight through. Buffered reports which of these happened.
+type BufferedResponseWriter struct {
+	rw        ResponseWriter
+	limit     int
+	status    int
+	body      bytes.Buffer
+	size      int
+	committed bool
+	hijacked  bool
+}
+
+// NewBufferedResponseWriter returns a BufferedResponseWriter that holds up to limit bytes of body before
+// streaming to rw. If limit is 0 or less, DefaultBufferLimit is used.
+func NewBufferedResponseWriter(rw ResponseWriter, limit int) *BufferedResponseWriter {
+	if limit <= 0 {
+		limit = DefaultBufferLimit
+	}
+	return &BufferedResponseWriter{rw: rw, limit: limit}
+}
+
+// BufferResponse returns middleware that buffers the response for the rest of the middleware stack and the
+// handler, and commits it once they return. Middleware after it can type assert the ResponseWriter it's given
+// to a *BufferedResponseWriter to post-process the response.
+func BufferResponse(limit int) func(ResponseWriter, *Request, NextMiddlewareFunc) {
+	return func(rw ResponseWriter, req *Request, next NextMiddlewareFunc) {
+		brw := NewBufferedResponseWriter(rw, limit)
+		next(brw, req)
+		brw.Commit()
+	}
+}
+
+// Header returns the header map that will be sent. Changes made after the response is committed have no effect.
+func (w *BufferedResponseWriter) Header() http.Header {
+	return w.rw.Header()
+}
+
+// WriteHeader sets the status code. Unlike a plain ResponseWriter, it can be called again to change the status
+// code as long as the response hasn't been committed.
+func (w *BufferedResponseWriter) WriteHeader(statusCode int) {
+	if w.committed {
+		w.rw.WriteHeader(statusCode)
+		return
+	}
+	w.status = statusCode
+}
+
+func (w *BufferedResponseWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.size += len(data)
+	if w.committed {
+		return w.rw.Write(data)
+	}
+	if w.body.Len()+len(data) > w.limit {
+		w.commit(false)
+		return w.rw.Write(data)
+	}
+	return w.body.Write(data)
+}
+
+// StatusCode returns the status code written so far, or 0 if none has been written yet.
+func (w *BufferedResponseWriter) StatusCode() int {
+	return w.status
+}
+
+// Written returns whether the handler has written a status code or any of the body.
+func (w *BufferedResponseWriter) Written() bool {
+	return w.status != 0
+}
+
+// Size returns the size in bytes of the body written so far, whether it's been sent yet or not.
+func (w *BufferedResponseWriter) Size() int {
+	return w.size
+}
+
+// Buffered returns whether the whole response is still held, so that the status, headers and Body can be changed.
+func (w *BufferedResponseWriter) Buffered() bool {
+	return !w.committed
+}
+
+// Body returns the buffered body. Once the response has been committed it returns nil.
+func (w *BufferedResponseWriter) Body() []byte {
+	if w.committed {
+		return nil
+	}
+	return w.body.Bytes()
+}
+
+// SetBody replaces the buffered body. It does nothing once the response has been committed.
+func (w *BufferedResponseWriter) SetBody(body []byte) {
+	if w.committed {
+		return
+	}
+	w.body.Reset()
+	w.body.Write(body)
+	w.size = len(body)
+}
+
+// Commit sends the buffered status, headers and body. When the whole body was buffered, Content-Length is set
+// to match it. After Commit, anything else written goes straight through. It's safe to call more than once.
+func (w *BufferedResponseWriter) Commit() {
+	if !w.committed {
+		w.commit(true)
+	}
+}
+
+func (w *BufferedResponseWriter) commit(complete bool) {
+	w.committed = true
+	if w.hijacked || w.status == 0 {
+		return
+	}
+	if complete && w.body.Len() > 0 && bodyAllowedForStatus(w.status) {
+		w.rw.Header().Set("Content-Length", strconv.Itoa(w.body.Len()))
+	}
+	w.rw.WriteHeader(w.status)
+	if w.body.Len() > 0 {
+		w.rw.Write(w.body.Bytes())
+	}
+	w.body = bytes.Buffer{}
+}
+
+// Flush commits the response and flushes it to the client. Anything written afterwards streams straight through,
+// so streaming handlers (eg, an EventStream) keep working behind a BufferedResponseWriter.
+func (w *BufferedResponseWriter) Flush() {
+	w.Commit()
+	w.rw.Flush()
+}
+
+// Hijack discards anything buffered and hijacks the underlying connection.
+func (w *BufferedResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := w.rw.Hijack()
+	if err == nil {
+		w.hijacked = true
+		w.committed = true
+		w.body = bytes.Buffer{}
+	}
+	return conn, brw, err
+}
+
+func (w *BufferedResponseWriter) CloseNotify() <-chan bool {
+	return w.rw.CloseNotify()
+}
+
+// Unwrap returns the underlying ResponseWriter.
+func (w *BufferedResponseWriter) Unwrap() http.ResponseWriter {
+	return w.rw
+}
+
+// bodyAllowedForStatus reports whether a response with status may have a body.
+func bodyAllowedForStatus(status int) bool {
+	switch {
+	case status >= 100 && status <= 199:
+		return false
+	case status == http.StatusNoContent, status == http.StatusNotModified:
+		return false
+	}
+	return true
+}
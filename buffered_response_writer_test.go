@@ -0,0 +1,100 @@
+package web
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestBufferResponse(t *testing.T) {
+	router := New(Context{})
+	router.Middleware(BufferResponse(0))
+	router.Middleware(func(rw ResponseWriter, req *Request, next NextMiddlewareFunc) {
+		next(rw, req)
+
+		// The handler has returned, but nothing has been sent yet:
+		brw := rw.(*BufferedResponseWriter)
+		assert.True(t, brw.Buffered())
+		assert.Equal(t, http.StatusCreated, brw.StatusCode())
+		assert.Equal(t, "created", string(brw.Body()))
+
+		brw.WriteHeader(http.StatusAccepted)
+		brw.Header().Set("X-Post-Processed", "yes")
+		brw.SetBody([]byte(strings.ToUpper(string(brw.Body())) + "!"))
+		assert.Equal(t, 8, brw.Size())
+	})
+	router.Post("/things", func(rw ResponseWriter, req *Request) {
+		rw.WriteHeader(http.StatusCreated)
+		rw.Write([]byte("created"))
+		assert.Equal(t, 7, rw.Size())
+	})
+
+	rw, req := newTestRequest("POST", "/things")
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "CREATED!", http.StatusAccepted)
+	assert.Equal(t, "yes", rw.Header().Get("X-Post-Processed"))
+	assert.Equal(t, "8", rw.Header().Get("Content-Length"))
+}
+
+func TestBufferedResponseWriterSpills(t *testing.T) {
+	router := New(Context{})
+	router.Middleware(func(rw ResponseWriter, req *Request, next NextMiddlewareFunc) {
+		brw := NewBufferedResponseWriter(rw, 10)
+		next(brw, req)
+		assert.False(t, brw.Buffered())
+		assert.Nil(t, brw.Body())
+		assert.Equal(t, 15, brw.Size())
+
+		// Too late to change anything:
+		brw.Header().Set("X-Too-Late", "yes")
+		brw.SetBody([]byte("ignored"))
+		brw.Commit()
+	})
+	router.Get("/big", func(rw ResponseWriter, req *Request) {
+		rw.Write([]byte("01234"))
+		rw.Write([]byte("56789"))
+		rw.Write([]byte("abcde"))
+	})
+
+	rw, req := newTestRequest("GET", "/big")
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "0123456789abcde", http.StatusOK)
+	assert.Equal(t, "", rw.Header().Get("Content-Length"))
+	assert.Equal(t, "", rw.Result().Header.Get("X-Too-Late"))
+}
+
+func TestBufferedResponseWriterFlush(t *testing.T) {
+	router := New(Context{})
+	router.Middleware(BufferResponse(0))
+	router.Get("/events", func(rw ResponseWriter, req *Request) {
+		stream := NewEventStream(rw, req)
+		assert.False(t, rw.(*BufferedResponseWriter).Buffered())
+		stream.Send(Event{Data: "hi"})
+	})
+
+	rw, req := newTestRequest("GET", "/events")
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "data: hi", http.StatusOK)
+	assert.True(t, rw.Flushed)
+	assert.Equal(t, "", rw.Header().Get("Content-Length"))
+}
+
+func TestBufferedResponseWriterNoContent(t *testing.T) {
+	router := New(Context{})
+	router.Middleware(BufferResponse(0))
+	router.Delete("/things/:id", func(rw ResponseWriter, req *Request) {
+		NoContent(rw)
+	})
+	router.Get("/empty", func(rw ResponseWriter, req *Request) {})
+
+	rw, req := newTestRequest("DELETE", "/things/1")
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "", http.StatusNoContent)
+	assert.Equal(t, "", rw.Header().Get("Content-Length"))
+
+	// Nothing written means nothing committed, so the usual defaults apply:
+	rw, req = newTestRequest("GET", "/empty")
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "", http.StatusOK)
+}
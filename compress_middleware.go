@@ -0,0 +1,299 @@
+package web
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Compressor is a compressing writer that CompressMiddleware can reuse across responses.
+// *gzip.Writer and *flate.Writer are Compressors, as are the writers of most third-party encoders (eg, brotli).
+type Compressor interface {
+	io.WriteCloser
+	// Flush writes any pending compressed data to the underlying writer.
+	Flush() error
+	// Reset discards the writer's state and makes it write to w, as if it were newly created.
+	Reset(w io.Writer)
+}
+
+// CompressorFunc returns a new Compressor that writes to w. See RegisterCompressor.
+type CompressorFunc func(w io.Writer) Compressor
+
+type contentCoding struct {
+	encoding string
+	pool     *sync.Pool
+}
+
+var compressors = struct {
+	sync.RWMutex
+	list []contentCoding
+}{}
+
+func init() {
+	RegisterCompressor("gzip", func(w io.Writer) Compressor {
+		return gzip.NewWriter(w)
+	})
+	RegisterCompressor("deflate", func(w io.Writer) Compressor {
+		fw, _ := flate.NewWriter(w, flate.DefaultCompression)
+		return fw
+	})
+}
+
+// RegisterCompressor makes CompressMiddleware able to compress responses with the content coding encoding
+// (eg "br"). Compressors registered earlier are preferred when the client accepts several equally, unless
+// CompressOption.Encodings says otherwise. Registering an existing encoding replaces its compressor.
+// gzip and deflate are registered by default, in that order.
+func RegisterCompressor(encoding string, fn CompressorFunc) {
+	pool := &sync.Pool{New: func() interface{} { return fn(nil) }}
+
+	compressors.Lock()
+	defer compressors.Unlock()
+	for i := range compressors.list {
+		if compressors.list[i].encoding == encoding {
+			compressors.list[i].pool = pool
+			return
+		}
+	}
+	compressors.list = append(compressors.list, contentCoding{encoding, pool})
+}
+
+// DefaultCompressContentTypes are the media types CompressMiddleware compresses unless CompressOption.ContentTypes is set.
+var DefaultCompressContentTypes = []string{
+	"text/*",
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"application/xhtml+xml",
+	"application/rss+xml",
+	"application/atom+xml",
+	"application/wasm",
+	"image/svg+xml",
+}
+
+// CompressOption configures CompressMiddleware.
+// MinSize is the smallest body that's compressed; smaller ones aren't worth it. Defaults to 1024 bytes.
+// ContentTypes are the media types to compress. An entry ending in "/*" matches a whole type. Defaults to
+// DefaultCompressContentTypes.
+// Encodings are the content codings to offer, in order of preference. Each must have been registered with
+// RegisterCompressor. Defaults to every registered encoding.
+type CompressOption struct {
+	MinSize      int
+	ContentTypes []string
+	Encodings    []string
+}
+
+// CompressMiddleware returns a middleware that compresses responses with whichever registered content coding
+// best matches the request's Accept-Encoding header. Responses are only compressed if they're at least MinSize
+// bytes (or the handler flushes them) and have a compressible Content-Type, and aren't already encoded or partial.
+// Compressed responses have no Content-Length or Accept-Ranges, and every response gets a Vary: Accept-Encoding header.
+func CompressMiddleware(options ...CompressOption) func(ResponseWriter, *Request, NextMiddlewareFunc) {
+	var option CompressOption
+	if len(options) > 0 {
+		option = options[0]
+	}
+	if option.MinSize <= 0 {
+		option.MinSize = 1024
+	}
+	if option.ContentTypes == nil {
+		option.ContentTypes = DefaultCompressContentTypes
+	}
+
+	return func(rw ResponseWriter, req *Request, next NextMiddlewareFunc) {
+		rw.Header().Add("Vary", "Accept-Encoding")
+		if req.Method == "HEAD" {
+			next(rw, req)
+			return
+		}
+
+		compressors.RLock()
+		list := compressors.list
+		compressors.RUnlock()
+
+		var offers []string
+		if option.Encodings != nil {
+			offers = append(offers, option.Encodings...)
+		} else {
+			for _, c := range list {
+				offers = append(offers, c.encoding)
+			}
+		}
+		encoding := req.negotiateCompression(offers)
+
+		var pool *sync.Pool
+		for _, c := range list {
+			if c.encoding == encoding {
+				pool = c.pool
+			}
+		}
+		if pool == nil {
+			next(rw, req)
+			return
+		}
+
+		crw := &compressResponseWriter{rw: rw, encoding: encoding, pool: pool, option: &option}
+		next(crw, req)
+		crw.close()
+	}
+}
+
+// compressResponseWriter holds the start of the body until it knows whether to compress it: once MinSize bytes
+// have been written, the handler flushes, or the handler returns.
+type compressResponseWriter struct {
+	rw       ResponseWriter
+	encoding string
+	pool     *sync.Pool
+	option   *CompressOption
+
+	status   int
+	size     int
+	pending  []byte
+	decided  bool
+	hijacked bool
+	cw       Compressor // nil unless the response is being compressed
+}
+
+func (w *compressResponseWriter) Header() http.Header {
+	return w.rw.Header()
+}
+
+func (w *compressResponseWriter) WriteHeader(statusCode int) {
+	if w.decided {
+		w.rw.WriteHeader(statusCode)
+		return
+	}
+	w.status = statusCode
+	if !bodyAllowedForStatus(statusCode) {
+		w.decide(false)
+	}
+}
+
+func (w *compressResponseWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.size += len(data)
+	if w.decided {
+		if w.cw != nil {
+			return w.cw.Write(data)
+		}
+		return w.rw.Write(data)
+	}
+	w.pending = append(w.pending, data...)
+	if len(w.pending) >= w.option.MinSize {
+		w.decide(true)
+	}
+	return len(data), nil
+}
+
+// decide sends the header, compressing the response if compress is set and its headers allow it,
+// followed by any pending body.
+func (w *compressResponseWriter) decide(compress bool) {
+	w.decided = true
+	if w.status == 0 {
+		return
+	}
+
+	header := w.rw.Header()
+	if header.Get("Content-Type") == "" && len(w.pending) > 0 {
+		// Sniff now, because once it's compressed net/http can't.
+		header.Set("Content-Type", http.DetectContentType(w.pending))
+	}
+	// Ranges are byte offsets into the uncompressed body, so a partial response can't be compressed, and a
+	// compressed one can't advertise ranges.
+	partial := w.status == http.StatusPartialContent || header.Get("Content-Range") != ""
+	if compress && bodyAllowedForStatus(w.status) && !partial && header.Get("Content-Encoding") == "" &&
+		w.compressible(header.Get("Content-Type")) {
+		header.Set("Content-Encoding", w.encoding)
+		header.Del("Content-Length")
+		header.Del("Accept-Ranges")
+		w.cw = w.pool.Get().(Compressor)
+		w.cw.Reset(w.rw)
+	}
+
+	w.rw.WriteHeader(w.status)
+	if len(w.pending) > 0 {
+		if w.cw != nil {
+			w.cw.Write(w.pending)
+		} else {
+			w.rw.Write(w.pending)
+		}
+	}
+	w.pending = nil
+}
+
+func (w *compressResponseWriter) compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, t := range w.option.ContentTypes {
+		if t == mediaType || (strings.HasSuffix(t, "/*") && strings.HasPrefix(mediaType, t[:len(t)-1])) {
+			return true
+		}
+	}
+	return false
+}
+
+// close finishes the response once the handler has returned.
+func (w *compressResponseWriter) close() {
+	if w.hijacked {
+		return
+	}
+	if !w.decided {
+		w.decide(false)
+	}
+	if w.cw != nil {
+		w.cw.Close()
+		w.cw.Reset(nil)
+		w.pool.Put(w.cw)
+		w.cw = nil
+	}
+}
+
+func (w *compressResponseWriter) StatusCode() int {
+	return w.status
+}
+
+func (w *compressResponseWriter) Written() bool {
+	return w.status != 0
+}
+
+// Size returns the size in bytes of the body written so far, before compression.
+func (w *compressResponseWriter) Size() int {
+	return w.size
+}
+
+// Flush starts compressing the response even if it's smaller than MinSize, and flushes whatever has been
+// compressed so far to the client.
+func (w *compressResponseWriter) Flush() {
+	if !w.decided {
+		w.decide(true)
+	}
+	if w.cw != nil {
+		w.cw.Flush()
+	}
+	w.rw.Flush()
+}
+
+func (w *compressResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := w.rw.Hijack()
+	if err == nil {
+		w.hijacked = true
+	}
+	return conn, brw, err
+}
+
+func (w *compressResponseWriter) CloseNotify() <-chan bool {
+	return w.rw.CloseNotify()
+}
+
+// Unwrap returns the underlying ResponseWriter.
+func (w *compressResponseWriter) Unwrap() http.ResponseWriter {
+	return w.rw
+}
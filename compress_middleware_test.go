@@ -0,0 +1,233 @@
+package web
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+var compressibleBody = strings.Repeat("compress me please ", 100)
+
+func newCompressRouter(options ...CompressOption) *Router {
+	router := New(Context{})
+	router.Middleware(CompressMiddleware(options...))
+	router.Get("/text", func(rw ResponseWriter, req *Request) {
+		Text(rw, http.StatusOK, compressibleBody)
+	})
+	router.Get("/small", func(rw ResponseWriter, req *Request) {
+		Text(rw, http.StatusOK, "small")
+	})
+	router.Get("/image", func(rw ResponseWriter, req *Request) {
+		rw.Header().Set("Content-Type", "image/png")
+		rw.Write([]byte(compressibleBody))
+	})
+	router.Get("/sniffed", func(rw ResponseWriter, req *Request) {
+		rw.Write([]byte("<html><body>" + compressibleBody + "</body></html>"))
+	})
+	router.Get("/stream", func(rw ResponseWriter, req *Request) {
+		stream := NewEventStream(rw, req)
+		stream.Send(Event{Data: "one"})
+		stream.Send(Event{Data: "two"})
+	})
+	router.Get("/missing", func(rw ResponseWriter, req *Request) {
+		rw.WriteHeader(http.StatusNotModified)
+	})
+	return router
+}
+
+func gunzip(t *testing.T, body []byte) string {
+	r, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(r)
+	assert.NoError(t, err)
+	return string(b)
+}
+
+func TestCompressMiddleware(t *testing.T) {
+	router := newCompressRouter()
+
+	rw, req := newTestRequest("GET", "/text")
+	req.Header.Set("Accept-Encoding", "deflate;q=0.5, gzip")
+	router.ServeHTTP(rw, req)
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, "gzip", rw.Header().Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", rw.Header().Get("Vary"))
+	assert.Equal(t, "", rw.Header().Get("Content-Length"))
+	assert.Equal(t, "text/plain; charset=utf-8", rw.Header().Get("Content-Type"))
+	assert.True(t, rw.Body.Len() < len(compressibleBody))
+	assert.Equal(t, compressibleBody, gunzip(t, rw.Body.Bytes()))
+
+	rw, req = newTestRequest("GET", "/text")
+	req.Header.Set("Accept-Encoding", "deflate")
+	router.ServeHTTP(rw, req)
+	assert.Equal(t, "deflate", rw.Header().Get("Content-Encoding"))
+	inflated, err := ioutil.ReadAll(flate.NewReader(rw.Body))
+	assert.NoError(t, err)
+	assert.Equal(t, compressibleBody, string(inflated))
+
+	// Content types without an explicit header are sniffed:
+	rw, req = newTestRequest("GET", "/sniffed")
+	req.Header.Set("Accept-Encoding", "gzip")
+	router.ServeHTTP(rw, req)
+	assert.Equal(t, "gzip", rw.Header().Get("Content-Encoding"))
+	assert.Equal(t, "text/html; charset=utf-8", rw.Header().Get("Content-Type"))
+}
+
+func TestCompressMiddlewareSkips(t *testing.T) {
+	router := newCompressRouter()
+
+	for _, tc := range []struct {
+		path, acceptEncoding string
+	}{
+		{"/text", ""},
+		{"/text", "br"},
+		{"/text", "gzip;q=0"},
+		{"/small", "gzip"},
+		{"/image", "gzip"},
+		{"/missing", "gzip"},
+	} {
+		rw, req := newTestRequest("GET", tc.path)
+		if tc.acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", tc.acceptEncoding)
+		}
+		router.ServeHTTP(rw, req)
+		assert.Equal(t, "", rw.Header().Get("Content-Encoding"), tc.path+" "+tc.acceptEncoding)
+		assert.Equal(t, "Accept-Encoding", rw.Header().Get("Vary"), tc.path+" "+tc.acceptEncoding)
+	}
+
+	// Without an Accept-Encoding header, the client might not understand any coding:
+	rw, req := newTestRequest("GET", "/text")
+	router.ServeHTTP(rw, req)
+	assert.Equal(t, "", rw.Header().Get("Content-Encoding"))
+	assert.Equal(t, compressibleBody, rw.Body.String())
+
+	rw, req = newTestRequest("GET", "/small")
+	req.Header.Set("Accept-Encoding", "gzip")
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "small", http.StatusOK)
+	assert.Equal(t, "5", rw.Header().Get("Content-Length"))
+
+	rw, req = newTestRequest("GET", "/missing")
+	req.Header.Set("Accept-Encoding", "gzip")
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "", http.StatusNotModified)
+
+	rw, req = newTestRequest("HEAD", "/text")
+	req.Header.Set("Accept-Encoding", "gzip")
+	router.ServeHTTP(rw, req)
+	assert.Equal(t, "", rw.Header().Get("Content-Encoding"))
+	assert.Equal(t, 0, rw.Body.Len())
+}
+
+func TestCompressMiddlewareRanges(t *testing.T) {
+	router := New(Context{})
+	router.Middleware(CompressMiddleware())
+	router.Middleware(StaticMiddlewareFromFS(fstest.MapFS{"big.txt": {Data: []byte(compressibleBody)}}))
+
+	// Ranges are of the uncompressed body, so a partial response isn't compressed:
+	rw, req := newTestRequest("GET", "/big.txt")
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("Range", "bytes=0-99")
+	router.ServeHTTP(rw, req)
+	assert.Equal(t, http.StatusPartialContent, rw.Code)
+	assert.Equal(t, "", rw.Header().Get("Content-Encoding"))
+	assert.Equal(t, "bytes 0-99/1900", rw.Header().Get("Content-Range"))
+	assert.Equal(t, compressibleBody[:100], rw.Body.String())
+
+	// A compressed response doesn't advertise ranges:
+	rw, req = newTestRequest("GET", "/big.txt")
+	req.Header.Set("Accept-Encoding", "gzip")
+	router.ServeHTTP(rw, req)
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, "gzip", rw.Header().Get("Content-Encoding"))
+	assert.Equal(t, "", rw.Header().Get("Accept-Ranges"))
+	assert.Equal(t, compressibleBody, gunzip(t, rw.Body.Bytes()))
+
+	rw, req = newTestRequest("GET", "/big.txt")
+	router.ServeHTTP(rw, req)
+	assert.Equal(t, "bytes", rw.Header().Get("Accept-Ranges"))
+}
+
+func TestCompressMiddlewareFlush(t *testing.T) {
+	router := newCompressRouter()
+
+	rw, req := newTestRequest("GET", "/stream")
+	req.Header.Set("Accept-Encoding", "gzip")
+	router.ServeHTTP(rw, req)
+	assert.True(t, rw.Flushed)
+	assert.Equal(t, "gzip", rw.Header().Get("Content-Encoding"))
+	assert.Equal(t, "data: one\n\ndata: two\n\n", gunzip(t, rw.Body.Bytes()))
+}
+
+type upperCompressor struct {
+	w io.Writer
+}
+
+func (c *upperCompressor) Write(p []byte) (int, error) {
+	return c.w.Write(bytes.ToUpper(p))
+}
+func (c *upperCompressor) Close() error      { return nil }
+func (c *upperCompressor) Flush() error      { return nil }
+func (c *upperCompressor) Reset(w io.Writer) { c.w = w }
+
+func TestRegisterCompressor(t *testing.T) {
+	RegisterCompressor("x-upper", func(w io.Writer) Compressor {
+		return &upperCompressor{w}
+	})
+	defer func() {
+		compressors.Lock()
+		compressors.list = compressors.list[:len(compressors.list)-1]
+		compressors.Unlock()
+	}()
+
+	rw, req := newTestRequest("GET", "/text")
+	req.Header.Set("Accept-Encoding", "gzip, x-upper")
+	newCompressRouter().ServeHTTP(rw, req)
+	assert.Equal(t, "gzip", rw.Header().Get("Content-Encoding"))
+
+	// Encodings sets the preference order:
+	rw, req = newTestRequest("GET", "/text")
+	req.Header.Set("Accept-Encoding", "gzip, x-upper")
+	newCompressRouter(CompressOption{Encodings: []string{"x-upper", "gzip"}}).ServeHTTP(rw, req)
+	assert.Equal(t, "x-upper", rw.Header().Get("Content-Encoding"))
+	assert.Equal(t, strings.ToUpper(compressibleBody), rw.Body.String())
+}
+
+type hijackableRecorder struct {
+	*httptest.ResponseRecorder
+	hijacked bool
+}
+
+func (h *hijackableRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h.hijacked = true
+	return nil, nil, nil
+}
+
+func TestCompressMiddlewareHijack(t *testing.T) {
+	router := New(Context{})
+	router.Middleware(CompressMiddleware())
+	router.Get("/ws", func(rw ResponseWriter, req *Request) {
+		rw.Write([]byte("written before hijacking"))
+		_, _, err := rw.Hijack()
+		assert.NoError(t, err)
+	})
+
+	rec := &hijackableRecorder{ResponseRecorder: httptest.NewRecorder()}
+	_, req := newTestRequest("GET", "/ws")
+	req.Header.Set("Accept-Encoding", "gzip")
+	router.ServeHTTP(rec, req)
+	assert.True(t, rec.hijacked)
+	assert.Equal(t, 0, rec.Body.Len())
+}
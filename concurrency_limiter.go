@@ -0,0 +1,334 @@
+package web
+
+import (
+	"container/heap"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ConcurrencyOption configures NewConcurrencyLimiter.
+type ConcurrencyOption struct {
+	// Limit is the number of requests that may be served at once. With AdaptiveLatency, it's the initial limit. It
+	// must be positive.
+	Limit int
+
+	// If set, up to QueueSize requests over the limit wait for a slot, instead of being shed right away.
+	QueueSize int
+
+	// If set, MaxWait is how long a queued request waits for a slot before it's shed. The default is a second.
+	MaxWait time.Duration
+
+	// If set, Priority returns the priority class of a request (eg, 1 for logged-in users, 0 for others, and -1 for
+	// crawlers). Higher classes are served from the queue first, and when the queue is full, a request may take the
+	// place of a queued request of a lower class, which is shed instead. The default class is 0.
+	Priority func(req *Request) int
+
+	// If set, RetryAfter is sent in the Retry-After header of shed requests, rounded up to seconds. The default is a
+	// second.
+	RetryAfter time.Duration
+
+	// If set, Routes caps some routes, keyed by method and route (eg, "POST /reports"), on top of Limit. Each route
+	// has its own queue. Their limits aren't adaptive. HEAD requests served by a GET route count against its "GET"
+	// limit.
+	Routes map[string]int
+
+	// If set, the limit adapts to the latency of requests, AIMD style: every request that takes less than
+	// AdaptiveLatency raises the limit a little (by one per limit's worth of requests), and every one that takes
+	// longer cuts it by Backoff.
+	AdaptiveLatency time.Duration
+
+	// If set, MinLimit and MaxLimit bound the adaptive limit. The defaults are 1 and 10 times Limit.
+	MinLimit int
+	MaxLimit int
+
+	// If set, Backoff is the factor the adaptive limit is multiplied by after a slow request. The default is 0.9.
+	Backoff float64
+}
+
+// ConcurrencyStats describes the state of a ConcurrencyLimiter, eg for metrics.
+type ConcurrencyStats struct {
+	Limit    int
+	InFlight int
+	Queued   int
+	Admitted uint64 // Requests served since the limiter was created
+	Shed     uint64 // Requests turned away since the limiter was created
+
+	// For the main limit, the stats of each of ConcurrencyOption.Routes.
+	Routes map[string]ConcurrencyStats
+}
+
+// ConcurrencyLimiter caps the number of requests served at once, to keep latency in check under load spikes.
+// Requests over the limit are queued or shed with a 503 Service Unavailable and a Retry-After header. Add its
+// Middleware to the root router to cap every request, or to a subrouter to cap just its routes.
+type ConcurrencyLimiter struct {
+	option ConcurrencyOption
+	limit  *concurrencyLimit
+	routes map[string]*concurrencyLimit
+}
+
+// NewConcurrencyLimiter returns a limiter configured by option. It panics if the limits in option are invalid.
+func NewConcurrencyLimiter(option ConcurrencyOption) *ConcurrencyLimiter {
+	if option.Limit <= 0 {
+		panic("web: ConcurrencyOption.Limit must be positive")
+	}
+	for route, limit := range option.Routes {
+		if limit <= 0 {
+			panic("web: ConcurrencyOption.Routes limit for " + route + " must be positive")
+		}
+	}
+	if option.MaxWait == 0 {
+		option.MaxWait = time.Second
+	}
+	if option.RetryAfter == 0 {
+		option.RetryAfter = time.Second
+	}
+	if option.MinLimit == 0 {
+		option.MinLimit = 1
+	}
+	if option.MaxLimit == 0 {
+		option.MaxLimit = 10 * option.Limit
+	}
+	if option.Backoff == 0 {
+		option.Backoff = 0.9
+	}
+	if option.MinLimit > option.MaxLimit {
+		panic("web: ConcurrencyOption.MinLimit is greater than MaxLimit")
+	}
+
+	l := &ConcurrencyLimiter{
+		option: option,
+		limit:  newConcurrencyLimit(option.Limit, option.QueueSize),
+		routes: make(map[string]*concurrencyLimit, len(option.Routes)),
+	}
+	for route, limit := range option.Routes {
+		l.routes[route] = newConcurrencyLimit(limit, option.QueueSize)
+	}
+	return l
+}
+
+// Middleware serves requests once they get a slot, and sheds the others.
+func (l *ConcurrencyLimiter) Middleware(rw ResponseWriter, req *Request, next NextMiddlewareFunc) {
+	priority := 0
+	if l.option.Priority != nil {
+		priority = l.option.Priority(req)
+	}
+
+	// Take the route's slot first, so that requests waiting for one don't hold a slot of the main limit.
+	var routeLimit *concurrencyLimit
+	if len(l.routes) > 0 {
+		routeLimit = l.routes[concurrencyRouteKey(req)]
+	}
+	if routeLimit != nil {
+		if !routeLimit.acquire(req, priority, l.option.MaxWait) {
+			l.shed(rw)
+			return
+		}
+		defer routeLimit.release()
+	}
+	if !l.limit.acquire(req, priority, l.option.MaxWait) {
+		l.shed(rw)
+		return
+	}
+
+	start := time.Now()
+	defer func() {
+		l.limit.release()
+		if l.option.AdaptiveLatency > 0 {
+			l.limit.adapt(time.Since(start) <= l.option.AdaptiveLatency, &l.option)
+		}
+	}()
+	next(rw, req)
+}
+
+// concurrencyRouteKey returns the method and route of req, eg "GET /users/:id", or "" if it has no route. HEAD
+// requests served by a GET route get the key of the GET route.
+func concurrencyRouteKey(req *Request) string {
+	route := req.route
+	if route == nil && req.rootRouter != nil {
+		route, _ = req.upcomingRoute()
+	}
+	if route == nil {
+		return ""
+	}
+	return string(route.Method) + " " + route.Path
+}
+
+func (l *ConcurrencyLimiter) shed(rw ResponseWriter) {
+	rw.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(l.option.RetryAfter)))
+	http.Error(rw, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+}
+
+// Stats returns the current state of the limiter.
+func (l *ConcurrencyLimiter) Stats() ConcurrencyStats {
+	stats := l.limit.stats()
+	if len(l.routes) > 0 {
+		stats.Routes = make(map[string]ConcurrencyStats, len(l.routes))
+		for route, limit := range l.routes {
+			stats.Routes[route] = limit.stats()
+		}
+	}
+	return stats
+}
+
+// concurrencyLimit is a counting semaphore with a priority queue.
+type concurrencyLimit struct {
+	mu        sync.Mutex
+	limit     float64 // Fractional, so that an adaptive limit can grow by less than one
+	inFlight  int
+	queue     concurrencyQueue
+	queueSize int
+	seq       uint64 // Orders waiters of the same priority
+	admitted  uint64
+	shed      uint64
+}
+
+const (
+	waiterQueued = iota
+	waiterAdmitted
+	waiterShed
+)
+
+type concurrencyWaiter struct {
+	priority int
+	seq      uint64
+	index    int // In the queue's heap
+	state    int
+	ready    chan struct{} // Closed when the state changes from waiterQueued
+}
+
+func newConcurrencyLimit(limit, queueSize int) *concurrencyLimit {
+	return &concurrencyLimit{limit: float64(limit), queueSize: queueSize}
+}
+
+// acquire waits for a slot, for up to maxWait, and returns whether it got one.
+func (c *concurrencyLimit) acquire(req *Request, priority int, maxWait time.Duration) bool {
+	c.mu.Lock()
+	if c.inFlight < int(c.limit) && len(c.queue) == 0 {
+		c.inFlight++
+		c.admitted++
+		c.mu.Unlock()
+		return true
+	}
+	if len(c.queue) >= c.queueSize {
+		// Take the place of the newest of the lowest priority waiters, if they're lower than this request.
+		lowest := -1
+		for i, w := range c.queue {
+			if lowest < 0 || w.priority < c.queue[lowest].priority || (w.priority == c.queue[lowest].priority && w.seq > c.queue[lowest].seq) {
+				lowest = i
+			}
+		}
+		if lowest < 0 || c.queue[lowest].priority >= priority {
+			c.shed++
+			c.mu.Unlock()
+			return false
+		}
+		evicted := heap.Remove(&c.queue, lowest).(*concurrencyWaiter)
+		c.finish(evicted, waiterShed)
+	}
+	c.seq++
+	w := &concurrencyWaiter{priority: priority, seq: c.seq, ready: make(chan struct{})}
+	heap.Push(&c.queue, w)
+	c.mu.Unlock()
+
+	timer := time.NewTimer(maxWait)
+	defer timer.Stop()
+	select {
+	case <-w.ready:
+	case <-timer.C:
+	case <-req.Context().Done():
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if w.state == waiterQueued {
+		heap.Remove(&c.queue, w.index)
+		c.finish(w, waiterShed)
+	}
+	return w.state == waiterAdmitted
+}
+
+// finish takes w out of the queue for good. c.mu must be held.
+func (c *concurrencyLimit) finish(w *concurrencyWaiter, state int) {
+	w.state = state
+	if state == waiterAdmitted {
+		c.admitted++
+	} else {
+		c.shed++
+	}
+	close(w.ready)
+}
+
+func (c *concurrencyLimit) release() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.inFlight--
+	c.admitQueued()
+}
+
+// admitQueued gives free slots to queued requests, highest priority first. c.mu must be held.
+func (c *concurrencyLimit) admitQueued() {
+	for c.inFlight < int(c.limit) && len(c.queue) > 0 {
+		w := heap.Pop(&c.queue).(*concurrencyWaiter)
+		c.inFlight++
+		c.finish(w, waiterAdmitted)
+	}
+}
+
+// adapt raises the limit a little after a fast request, and cuts it after a slow one.
+func (c *concurrencyLimit) adapt(fast bool, option *ConcurrencyOption) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if fast {
+		c.limit = math.Min(float64(option.MaxLimit), c.limit+1/c.limit)
+		c.admitQueued()
+	} else {
+		c.limit = math.Max(float64(option.MinLimit), c.limit*option.Backoff)
+	}
+}
+
+func (c *concurrencyLimit) stats() ConcurrencyStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return ConcurrencyStats{
+		Limit:    int(c.limit),
+		InFlight: c.inFlight,
+		Queued:   len(c.queue),
+		Admitted: c.admitted,
+		Shed:     c.shed,
+	}
+}
+
+// concurrencyQueue is a heap of waiters, highest priority (and then oldest) first.
+type concurrencyQueue []*concurrencyWaiter
+
+func (q concurrencyQueue) Len() int { return len(q) }
+
+func (q concurrencyQueue) Less(i, j int) bool {
+	if q[i].priority != q[j].priority {
+		return q[i].priority > q[j].priority
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q concurrencyQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *concurrencyQueue) Push(x interface{}) {
+	w := x.(*concurrencyWaiter)
+	w.index = len(*q)
+	*q = append(*q, w)
+}
+
+func (q *concurrencyQueue) Pop() interface{} {
+	old := *q
+	w := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	return w
+}
@@ -0,0 +1,196 @@
+package web
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newConcurrencyRouter returns a router whose /work/:name handler records the names it serves, and blocks
+// requests named "block" until unblock is closed.
+func newConcurrencyRouter(limiter *ConcurrencyLimiter, unblock chan struct{}) (*Router, func() []string) {
+	var mu sync.Mutex
+	var served []string
+	router := New(Context{})
+	router.Middleware(limiter.Middleware)
+	router.Get("/work/:name", func(rw ResponseWriter, req *Request) {
+		mu.Lock()
+		served = append(served, req.PathParams["name"])
+		mu.Unlock()
+		if req.PathParams["name"] == "block" {
+			<-unblock
+		}
+		rw.Write([]byte("done"))
+	})
+	router.Get("/other", (*Context).A)
+	return router, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), served...)
+	}
+}
+
+// serveAsync serves a request in the background, and returns a channel that gets its response.
+func serveAsync(router *Router, path string, priority int) chan *httptest.ResponseRecorder {
+	result := make(chan *httptest.ResponseRecorder, 1)
+	rw, req := newTestRequest("GET", path)
+	req.Header.Set("X-Priority", strconv.Itoa(priority))
+	go func() {
+		router.ServeHTTP(rw, req)
+		result <- rw
+	}()
+	return result
+}
+
+func waitForStats(t *testing.T, limiter *ConcurrencyLimiter, inFlight, queued int) {
+	for i := 0; i < 1000; i++ {
+		stats := limiter.Stats()
+		if stats.InFlight == inFlight && stats.Queued == queued {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("expected %d in flight and %d queued, got %+v", inFlight, queued, limiter.Stats())
+}
+
+func TestConcurrencyLimiterShed(t *testing.T) {
+	unblock := make(chan struct{})
+	limiter := NewConcurrencyLimiter(ConcurrencyOption{Limit: 1, RetryAfter: 1500 * time.Millisecond})
+	router, _ := newConcurrencyRouter(limiter, unblock)
+
+	blocked := serveAsync(router, "/work/block", 0)
+	waitForStats(t, limiter, 1, 0)
+
+	rw, req := newTestRequest("GET", "/other")
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "Service Unavailable", 503)
+	assert.Equal(t, "2", rw.Header().Get("Retry-After"))
+
+	close(unblock)
+	assertResponse(t, <-blocked, "done", 200)
+	rw, req = newTestRequest("GET", "/other")
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "context-A", 200)
+
+	assert.Equal(t, ConcurrencyStats{Limit: 1, Admitted: 2, Shed: 1}, limiter.Stats())
+}
+
+func TestConcurrencyLimiterQueue(t *testing.T) {
+	unblock := make(chan struct{})
+	limiter := NewConcurrencyLimiter(ConcurrencyOption{
+		Limit:     1,
+		QueueSize: 2,
+		MaxWait:   time.Minute,
+		Priority: func(req *Request) int {
+			priority, _ := strconv.Atoi(req.Header.Get("X-Priority"))
+			return priority
+		},
+	})
+	router, served := newConcurrencyRouter(limiter, unblock)
+
+	blocked := serveAsync(router, "/work/block", 0)
+	waitForStats(t, limiter, 1, 0)
+	low := serveAsync(router, "/work/low", 0)
+	waitForStats(t, limiter, 1, 1)
+	medium := serveAsync(router, "/work/medium", 1)
+	waitForStats(t, limiter, 1, 2)
+
+	// The queue is full. A higher priority request takes the place of the lowest one:
+	high := serveAsync(router, "/work/high", 2)
+	assertResponse(t, <-low, "Service Unavailable", 503)
+	waitForStats(t, limiter, 1, 2)
+	// But a lower one is shed:
+	assertResponse(t, <-serveAsync(router, "/work/lowest", -1), "Service Unavailable", 503)
+
+	close(unblock)
+	assertResponse(t, <-blocked, "done", 200)
+	assertResponse(t, <-high, "done", 200)
+	assertResponse(t, <-medium, "done", 200)
+	assert.Equal(t, []string{"block", "high", "medium"}, served())
+	assert.Equal(t, ConcurrencyStats{Limit: 1, Admitted: 3, Shed: 2}, limiter.Stats())
+}
+
+func TestConcurrencyLimiterMaxWait(t *testing.T) {
+	unblock := make(chan struct{})
+	limiter := NewConcurrencyLimiter(ConcurrencyOption{Limit: 1, QueueSize: 1, MaxWait: 10 * time.Millisecond})
+	router, _ := newConcurrencyRouter(limiter, unblock)
+
+	blocked := serveAsync(router, "/work/block", 0)
+	waitForStats(t, limiter, 1, 0)
+	rw, req := newTestRequest("GET", "/other")
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "Service Unavailable", 503)
+	assert.Equal(t, 0, limiter.Stats().Queued)
+
+	close(unblock)
+	<-blocked
+}
+
+func TestConcurrencyLimiterRoutes(t *testing.T) {
+	unblock := make(chan struct{})
+	limiter := NewConcurrencyLimiter(ConcurrencyOption{Limit: 10, Routes: map[string]int{"GET /work/:name": 1}})
+	router, _ := newConcurrencyRouter(limiter, unblock)
+
+	blocked := serveAsync(router, "/work/block", 0)
+	waitForStats(t, limiter, 1, 0)
+
+	rw, req := newTestRequest("GET", "/work/other")
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "Service Unavailable", 503)
+	// HEAD requests are served by the GET route, and count against its limit:
+	rw, req = newTestRequest("HEAD", "/work/other")
+	router.ServeHTTP(rw, req)
+	assert.Equal(t, 503, rw.Code)
+	rw, req = newTestRequest("GET", "/other")
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "context-A", 200)
+
+	stats := limiter.Stats()
+	assert.Equal(t, 1, stats.InFlight)
+	assert.Equal(t, ConcurrencyStats{Limit: 1, InFlight: 1, Admitted: 1, Shed: 2}, stats.Routes["GET /work/:name"])
+
+	close(unblock)
+	<-blocked
+}
+
+func TestConcurrencyLimiterValidation(t *testing.T) {
+	assert.Panics(t, func() { NewConcurrencyLimiter(ConcurrencyOption{}) })
+	assert.Panics(t, func() { NewConcurrencyLimiter(ConcurrencyOption{Limit: -1}) })
+	assert.Panics(t, func() { NewConcurrencyLimiter(ConcurrencyOption{Limit: 1, Routes: map[string]int{"GET /x": 0}}) })
+	assert.Panics(t, func() {
+		NewConcurrencyLimiter(ConcurrencyOption{Limit: 10, AdaptiveLatency: time.Second, MinLimit: 20, MaxLimit: 15})
+	})
+	// MaxLimit defaults to 10 times Limit:
+	assert.Panics(t, func() { NewConcurrencyLimiter(ConcurrencyOption{Limit: 1, MinLimit: 20}) })
+}
+
+func TestConcurrencyLimitAdapt(t *testing.T) {
+	limiter := NewConcurrencyLimiter(ConcurrencyOption{Limit: 10, AdaptiveLatency: time.Second, MaxLimit: 12})
+	limit := limiter.limit
+
+	limit.adapt(false, &limiter.option)
+	assert.Equal(t, 9, limiter.Stats().Limit)
+	// Each fast request adds 1/limit:
+	for i := 0; i < 10; i++ {
+		limit.adapt(true, &limiter.option)
+	}
+	assert.Equal(t, 10, limiter.Stats().Limit)
+	for i := 0; i < 100; i++ {
+		limit.adapt(true, &limiter.option)
+	}
+	assert.Equal(t, 12, limiter.Stats().Limit)
+	for i := 0; i < 100; i++ {
+		limit.adapt(false, &limiter.option)
+	}
+	assert.Equal(t, 1, limiter.Stats().Limit)
+
+	// Through the middleware:
+	limiter = NewConcurrencyLimiter(ConcurrencyOption{Limit: 10, AdaptiveLatency: time.Nanosecond})
+	router, _ := newConcurrencyRouter(limiter, nil)
+	rw, req := newTestRequest("GET", "/other")
+	router.ServeHTTP(rw, req)
+	assert.Equal(t, 9, limiter.Stats().Limit)
+}
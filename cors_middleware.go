@@ -0,0 +1,157 @@
+package web
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSOption configures CORSMiddleware.
+type CORSOption struct {
+	// If set, AllowedOrigins are the origins (eg, "https://example.com") that may make cross-origin requests.
+	// An origin may contain one "*" wildcard, eg "https://*.example.com", and "*" on its own allows every origin.
+	// If neither AllowedOrigins nor AllowedOriginPatterns is set, every origin is allowed.
+	AllowedOrigins []string
+
+	// If set, origins that match any of AllowedOriginPatterns are allowed too.
+	AllowedOriginPatterns []*regexp.Regexp
+
+	// If set, AllowedHeaders are the request headers a preflight allows. Otherwise the headers the preflight asks
+	// for (in Access-Control-Request-Headers) are allowed.
+	AllowedHeaders []string
+
+	// If set, ExposedHeaders are the response headers that scripts may read, beyond the CORS-safelisted ones.
+	ExposedHeaders []string
+
+	// If set, requests may include credentials (cookies, HTTP auth). The allowed origin is always echoed back
+	// in that case, since browsers refuse "*" with credentials. It can't be combined with allowing every origin,
+	// which would let any website make requests with the user's credentials.
+	AllowCredentials bool
+
+	// If set, MaxAge is how long browsers may cache a preflight's result. It's rounded down to whole seconds.
+	MaxAge time.Duration
+
+	// If set, preflights from public websites that ask for access to a private network
+	// (Access-Control-Request-Private-Network) are allowed.
+	AllowPrivateNetwork bool
+}
+
+// CORSMiddleware returns a middleware that implements Cross-Origin Resource Sharing. Preflight requests
+// (OPTIONS requests with Access-Control-Request-Method) from allowed origins are answered directly, with
+// Access-Control-Allow-Methods listing the methods routed for the path, and 204 No Content. Other requests from
+// allowed origins get Access-Control-Allow-Origin and are passed on. Requests from other origins are passed on
+// untouched, so paths without routes still 404.
+func CORSMiddleware(options ...CORSOption) func(ResponseWriter, *Request, NextMiddlewareFunc) {
+	var option CORSOption
+	if len(options) > 0 {
+		option = options[0]
+	}
+	c := &corsHandler{option: option}
+	if len(option.AllowedOrigins) == 0 && len(option.AllowedOriginPatterns) == 0 {
+		c.allowAll = true
+	}
+	for _, origin := range option.AllowedOrigins {
+		if origin == "*" {
+			c.allowAll = true
+		} else {
+			c.origins = append(c.origins, strings.ToLower(origin))
+		}
+	}
+	if c.allowAll && option.AllowCredentials {
+		panic("web: CORSMiddleware can't allow credentials from every origin; set AllowedOrigins")
+	}
+	return c.serve
+}
+
+type corsHandler struct {
+	option   CORSOption
+	allowAll bool
+	origins  []string // lowercased, with any wildcard kept in place
+}
+
+func (c *corsHandler) serve(rw ResponseWriter, req *Request, next NextMiddlewareFunc) {
+	header := rw.Header()
+	// Unless every origin gets the same "*", the response depends on the Origin header, so caches must key on it.
+	if !c.allowAll {
+		header.Add("Vary", "Origin")
+	}
+
+	origin := req.Header.Get("Origin")
+	if origin == "" || !c.allowed(origin) {
+		next(rw, req)
+		return
+	}
+
+	requestMethod := req.Header.Get("Access-Control-Request-Method")
+	if httpMethod(req.Method) != httpMethodOptions || requestMethod == "" || req.rootRouter == nil {
+		c.setOrigin(header, origin)
+		if len(c.option.ExposedHeaders) > 0 {
+			header.Set("Access-Control-Expose-Headers", strings.Join(c.option.ExposedHeaders, ", "))
+		}
+		next(rw, req)
+		return
+	}
+
+	// A preflight. Let the router 404 it if nothing is routed here.
+	methods, _, _ := req.rootRouter.allowedMethods(req.URL.Path, requestMethod)
+	if len(methods) == 0 {
+		next(rw, req)
+		return
+	}
+
+	header.Add("Vary", "Access-Control-Request-Method")
+	header.Add("Vary", "Access-Control-Request-Headers")
+	c.setOrigin(header, origin)
+	header.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+	if len(c.option.AllowedHeaders) > 0 {
+		header.Set("Access-Control-Allow-Headers", strings.Join(c.option.AllowedHeaders, ", "))
+	} else if requestHeaders := req.Header.Get("Access-Control-Request-Headers"); requestHeaders != "" {
+		header.Set("Access-Control-Allow-Headers", requestHeaders)
+	}
+	if c.option.MaxAge > 0 {
+		header.Set("Access-Control-Max-Age", strconv.Itoa(int(c.option.MaxAge/time.Second)))
+	}
+	if c.option.AllowPrivateNetwork {
+		header.Add("Vary", "Access-Control-Request-Private-Network")
+		if req.Header.Get("Access-Control-Request-Private-Network") == "true" {
+			header.Set("Access-Control-Allow-Private-Network", "true")
+		}
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (c *corsHandler) setOrigin(header http.Header, origin string) {
+	if c.allowAll {
+		header.Set("Access-Control-Allow-Origin", "*")
+		return
+	}
+	header.Set("Access-Control-Allow-Origin", origin)
+	if c.option.AllowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+func (c *corsHandler) allowed(origin string) bool {
+	if c.allowAll {
+		return true
+	}
+	lower := strings.ToLower(origin)
+	for _, allowed := range c.origins {
+		if star := strings.IndexByte(allowed, '*'); star >= 0 {
+			prefix, suffix := allowed[:star], allowed[star+1:]
+			if len(lower) > len(prefix)+len(suffix) && strings.HasPrefix(lower, prefix) && strings.HasSuffix(lower, suffix) {
+				return true
+			}
+		} else if lower == allowed {
+			return true
+		}
+	}
+	for _, pattern := range c.option.AllowedOriginPatterns {
+		if pattern.MatchString(origin) {
+			return true
+		}
+	}
+	return false
+}
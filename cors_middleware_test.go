@@ -0,0 +1,125 @@
+package web
+
+import (
+	"github.com/stretchr/testify/assert"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func newCORSRouter(option CORSOption) *Router {
+	router := New(Context{})
+	router.Middleware(CORSMiddleware(option))
+	router.Get("/action", (*Context).A)
+	router.Put("/action", (*Context).A)
+	router.Delete("/other", (*Context).A)
+	return router
+}
+
+func TestCORSPreflight(t *testing.T) {
+	router := newCORSRouter(CORSOption{
+		AllowedOrigins:      []string{"https://example.com"},
+		MaxAge:              10 * time.Minute,
+		AllowCredentials:    true,
+		AllowPrivateNetwork: true,
+	})
+
+	rw, req := newTestRequest("OPTIONS", "/action")
+	req.Header.Set("Origin", "https://example.com")
+	req.Header.Set("Access-Control-Request-Method", "PUT")
+	req.Header.Set("Access-Control-Request-Headers", "X-Token")
+	req.Header.Set("Access-Control-Request-Private-Network", "true")
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "", 204)
+	assert.Equal(t, "https://example.com", rw.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "GET, PUT", rw.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "X-Token", rw.Header().Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "true", rw.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, "600", rw.Header().Get("Access-Control-Max-Age"))
+	assert.Equal(t, "true", rw.Header().Get("Access-Control-Allow-Private-Network"))
+	assert.Equal(t, []string{"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers", "Access-Control-Request-Private-Network"}, rw.Header()["Vary"])
+
+	// Disallowed origins fall through to the default OPTIONS handling:
+	rw, req = newTestRequest("OPTIONS", "/action")
+	req.Header.Set("Origin", "https://evil.com")
+	req.Header.Set("Access-Control-Request-Method", "PUT")
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "", 200)
+	assert.Equal(t, "", rw.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, []string{"Origin"}, rw.Header()["Vary"])
+
+	// Unrouted paths still 404:
+	rw, req = newTestRequest("OPTIONS", "/missing")
+	req.Header.Set("Origin", "https://example.com")
+	req.Header.Set("Access-Control-Request-Method", "GET")
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "Not Found", 404)
+	assert.Equal(t, "", rw.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestCORSSimpleRequest(t *testing.T) {
+	router := newCORSRouter(CORSOption{ExposedHeaders: []string{"X-Total", "X-Page"}})
+
+	rw, req := newTestRequest("GET", "/action")
+	req.Header.Set("Origin", "https://anywhere.com")
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "context-A", 200)
+	assert.Equal(t, "*", rw.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "X-Total, X-Page", rw.Header().Get("Access-Control-Expose-Headers"))
+	assert.Equal(t, "", rw.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, "", rw.Header().Get("Vary"))
+
+	// Without an Origin, it's not a CORS request:
+	rw, req = newTestRequest("GET", "/action")
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "context-A", 200)
+	assert.Equal(t, "", rw.Header().Get("Access-Control-Allow-Origin"))
+
+	// A plain OPTIONS request isn't a preflight:
+	rw, req = newTestRequest("OPTIONS", "/other")
+	req.Header.Set("Origin", "https://anywhere.com")
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "", 200)
+	assert.Equal(t, "*", rw.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "DELETE", rw.Header().Get("Access-Control-Allow-Methods"))
+}
+
+func TestCORSAllowedOrigins(t *testing.T) {
+	router := newCORSRouter(CORSOption{
+		AllowedOrigins:        []string{"https://example.com", "https://*.example.org"},
+		AllowedOriginPatterns: []*regexp.Regexp{regexp.MustCompile(`^http://localhost:\d+$`)},
+	})
+
+	for origin, allowed := range map[string]bool{
+		"https://example.com":      true,
+		"https://EXAMPLE.com":      true,
+		"https://a.example.org":    true,
+		"https://a.b.example.org":  true,
+		"https://.example.org":     false,
+		"https://example.org":      false,
+		"http://localhost:3000":    true,
+		"http://localhost:3000.io": false,
+		"https://example.com.evil": false,
+	} {
+		rw, req := newTestRequest("GET", "/action")
+		req.Header.Set("Origin", origin)
+		router.ServeHTTP(rw, req)
+		assertResponse(t, rw, "context-A", 200)
+		assert.Equal(t, "Origin", rw.Header().Get("Vary"), origin)
+		if allowed {
+			assert.Equal(t, origin, rw.Header().Get("Access-Control-Allow-Origin"), origin)
+		} else {
+			assert.Equal(t, "", rw.Header().Get("Access-Control-Allow-Origin"), origin)
+		}
+	}
+}
+
+func TestCORSCredentialsNeedOrigins(t *testing.T) {
+	assert.Panics(t, func() { CORSMiddleware(CORSOption{AllowCredentials: true}) })
+	assert.Panics(t, func() {
+		CORSMiddleware(CORSOption{AllowedOrigins: []string{"https://example.com", "*"}, AllowCredentials: true})
+	})
+	assert.NotPanics(t, func() {
+		CORSMiddleware(CORSOption{AllowedOrigins: []string{"https://*.example.com"}, AllowCredentials: true})
+	})
+}
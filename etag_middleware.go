@@ -0,0 +1,139 @@
+package web
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ETagOption configures ETagMiddleware.
+// If Weak is set, generated ETags are weak (W/"..."). Use this if a later step (eg, compression) may change the
+// bytes of the body without changing its meaning.
+// BufferLimit is the largest body an ETag is generated for. Larger responses are streamed without one.
+// Defaults to DefaultBufferLimit.
+type ETagOption struct {
+	Weak        bool
+	BufferLimit int
+}
+
+// ETagMiddleware returns a middleware that answers conditional GET and HEAD requests. It buffers the response,
+// and if the handler didn't set an ETag header itself, sets one computed from the body. If the request's
+// If-None-Match matches the ETag, or (without If-None-Match) its If-Modified-Since is no earlier than the
+// handler's Last-Modified header, a 304 Not Modified is sent instead of the body. HEAD requests are served with a
+// body, as GET requests are, so that they get the same ETag; it's dropped once the ETag is computed.
+//
+// Responses that aren't 2xx, partial (206) responses, responses that are streamed (eg, because the handler flushed), and
+// responses that are larger than the buffer limit are passed through unchanged.
+func ETagMiddleware(options ...ETagOption) func(ResponseWriter, *Request, NextMiddlewareFunc) {
+	var option ETagOption
+	if len(options) > 0 {
+		option = options[0]
+	}
+	return func(rw ResponseWriter, req *Request, next NextMiddlewareFunc) {
+		if req.Method != "GET" && req.Method != "HEAD" {
+			next(rw, req)
+			return
+		}
+
+		brw := NewBufferedResponseWriter(rw, option.BufferLimit)
+		if req.Method == "HEAD" {
+			serveHeadBody(brw, req, next)
+		} else {
+			next(brw, req)
+		}
+		// A partial response is only part of the representation, so it can't be given the representation's ETag.
+		status := brw.StatusCode()
+		partial := status == http.StatusPartialContent || brw.Header().Get("Content-Range") != ""
+		if brw.Buffered() && status >= 200 && status <= 299 && !partial {
+			applyETag(brw, req, option.Weak)
+			if req.Method == "HEAD" && brw.StatusCode() != http.StatusNotModified {
+				// The body was only needed for the ETag.
+				if len(brw.Body()) > 0 && brw.Header().Get("Content-Length") == "" {
+					brw.Header().Set("Content-Length", strconv.Itoa(len(brw.Body())))
+				}
+				brw.SetBody(nil)
+			}
+		}
+		brw.Commit()
+	}
+}
+
+// serveHeadBody serves a HEAD request with the render helpers writing the body, as they would for a GET, so that
+// the HEAD response gets the same ETag as the GET one.
+func serveHeadBody(brw *BufferedResponseWriter, req *Request, next NextMiddlewareFunc) {
+	w := unwrapAppResponseWriter(brw)
+	if w == nil || !w.head {
+		next(brw, req)
+		return
+	}
+	w.head = false
+	defer func() { w.head = true }()
+	next(brw, req)
+}
+
+// applyETag sets the ETag on a buffered response, and turns it into a 304 if the client's copy is current.
+func applyETag(brw *BufferedResponseWriter, req *Request, weak bool) {
+	header := brw.Header()
+	etag := header.Get("ETag")
+	if etag == "" && len(brw.Body()) > 0 {
+		etag = generateETag(brw.Body(), weak)
+		header.Set("ETag", etag)
+	}
+
+	if notModified(req, etag, header.Get("Last-Modified")) {
+		// As in http.ServeContent, headers that describe the body are dropped from a 304.
+		delete(header, "Content-Type")
+		delete(header, "Content-Length")
+		delete(header, "Content-Encoding")
+		if etag != "" {
+			delete(header, "Last-Modified")
+		}
+		brw.SetBody(nil)
+		brw.WriteHeader(http.StatusNotModified)
+	}
+}
+
+func generateETag(body []byte, weak bool) string {
+	sum := sha1.Sum(body)
+	etag := `"` + base64.RawURLEncoding.EncodeToString(sum[:]) + `"`
+	if weak {
+		return "W/" + etag
+	}
+	return etag
+}
+
+// notModified returns whether req's conditional headers show that the client's copy is current.
+func notModified(req *Request, etag, lastModified string) bool {
+	if inm := req.Header.Get("If-None-Match"); inm != "" {
+		return etag != "" && etagMatches(inm, etag)
+	}
+
+	ims := req.Header.Get("If-Modified-Since")
+	if ims == "" || lastModified == "" {
+		return false
+	}
+	since, err := http.ParseTime(ims)
+	if err != nil {
+		return false
+	}
+	modified, err := http.ParseTime(lastModified)
+	if err != nil {
+		return false
+	}
+	return !modified.Truncate(time.Second).After(since)
+}
+
+// etagMatches reports whether etag is in the If-None-Match list, using the weak comparison that RFC 7232 requires.
+func etagMatches(ifNoneMatch, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,132 @@
+package web
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newETagRouter(options ...ETagOption) *Router {
+	router := New(Context{})
+	router.Middleware(ETagMiddleware(options...))
+	router.Get("/widgets", func(rw ResponseWriter, req *Request) {
+		JSON(rw, http.StatusOK, []string{"a", "b"})
+	})
+	router.Get("/tagged", func(rw ResponseWriter, req *Request) {
+		rw.Header().Set("ETag", `"v42"`)
+		rw.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+		Text(rw, http.StatusOK, "tagged")
+	})
+	router.Get("/dated", func(rw ResponseWriter, req *Request) {
+		rw.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+		Text(rw, http.StatusOK, "dated")
+	})
+	router.Get("/missing", func(rw ResponseWriter, req *Request) {
+		Text(rw, http.StatusNotFound, "missing")
+	})
+	router.Get("/big", func(rw ResponseWriter, req *Request) {
+		Text(rw, http.StatusOK, strings.Repeat("x", 100))
+	})
+	router.Get("/partial", func(rw ResponseWriter, req *Request) {
+		rw.Header().Set("Content-Range", "bytes 0-3/10")
+		Text(rw, http.StatusPartialContent, "part")
+	})
+	router.Post("/widgets", func(rw ResponseWriter, req *Request) {
+		Text(rw, http.StatusOK, "posted")
+	})
+	return router
+}
+
+func TestETagMiddlewareGeneratesETags(t *testing.T) {
+	router := newETagRouter()
+
+	rw, req := newTestRequest("GET", "/widgets")
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, `["a","b"]`, http.StatusOK)
+	etag := rw.Header().Get("ETag")
+	assert.Regexp(t, `^"[A-Za-z0-9_-]{27}"$`, etag)
+
+	// The same body always gets the same ETag:
+	rw, req = newTestRequest("GET", "/widgets")
+	router.ServeHTTP(rw, req)
+	assert.Equal(t, etag, rw.Header().Get("ETag"))
+
+	rw, req = newTestRequest("GET", "/widgets")
+	req.Header.Set("If-None-Match", `"other", W/`+etag)
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "", http.StatusNotModified)
+	assert.Equal(t, etag, rw.Header().Get("ETag"))
+	assert.Equal(t, "", rw.Header().Get("Content-Type"))
+	assert.Equal(t, "", rw.Header().Get("Content-Length"))
+
+	rw, req = newTestRequest("GET", "/widgets")
+	req.Header.Set("If-None-Match", `"other"`)
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, `["a","b"]`, http.StatusOK)
+
+	// HEAD gets the same ETag, and 304s:
+	rw, req = newTestRequest("HEAD", "/widgets")
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "", http.StatusOK)
+	assert.Equal(t, etag, rw.Header().Get("ETag"))
+	assert.Equal(t, "10", rw.Header().Get("Content-Length"))
+
+	rw, req = newTestRequest("HEAD", "/widgets")
+	req.Header.Set("If-None-Match", etag)
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "", http.StatusNotModified)
+	assert.Equal(t, etag, rw.Header().Get("ETag"))
+
+	// Weak ETags:
+	rw, req = newTestRequest("GET", "/widgets")
+	newETagRouter(ETagOption{Weak: true}).ServeHTTP(rw, req)
+	assert.Equal(t, "W/"+etag, rw.Header().Get("ETag"))
+}
+
+func TestETagMiddlewareHandlerValidators(t *testing.T) {
+	router := newETagRouter()
+
+	rw, req := newTestRequest("GET", "/tagged")
+	req.Header.Set("If-None-Match", `"v42"`)
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "", http.StatusNotModified)
+	assert.Equal(t, `"v42"`, rw.Header().Get("ETag"))
+	assert.Equal(t, "", rw.Header().Get("Last-Modified"))
+
+	// If-None-Match takes precedence over If-Modified-Since:
+	rw, req = newTestRequest("GET", "/tagged")
+	req.Header.Set("If-None-Match", `"v41"`)
+	req.Header.Set("If-Modified-Since", time.Now().UTC().Format(http.TimeFormat))
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "tagged", http.StatusOK)
+
+	rw, req = newTestRequest("GET", "/dated")
+	req.Header.Set("If-Modified-Since", "Mon, 02 Jan 2006 15:04:05 GMT")
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "", http.StatusNotModified)
+
+	rw, req = newTestRequest("GET", "/dated")
+	req.Header.Set("If-Modified-Since", "Sun, 01 Jan 2006 15:04:05 GMT")
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "dated", http.StatusOK)
+}
+
+func TestETagMiddlewareSkips(t *testing.T) {
+	router := newETagRouter(ETagOption{BufferLimit: 50})
+
+	for _, path := range []string{"/missing", "/big", "/partial"} {
+		rw, req := newTestRequest("GET", path)
+		req.Header.Set("If-None-Match", "*")
+		router.ServeHTTP(rw, req)
+		assert.NotEqual(t, http.StatusNotModified, rw.Code, path)
+		assert.Equal(t, "", rw.Header().Get("ETag"), path)
+	}
+
+	rw, req := newTestRequest("POST", "/widgets")
+	req.Header.Set("If-None-Match", "*")
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "posted", http.StatusOK)
+	assert.Equal(t, "", rw.Header().Get("ETag"))
+}
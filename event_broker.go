@@ -0,0 +1,201 @@
+package web
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// ErrSlowConsumer is returned by EventBroker.Subscribe when a client was disconnected because it couldn't keep up.
+var ErrSlowConsumer = errors.New("web: event stream client is too slow")
+
+// EventBrokerOptions configures an EventBroker. Zero values get sensible defaults.
+type EventBrokerOptions struct {
+	// BufferSize is how many events can be queued for each client. A client whose queue is full when an event is
+	// published is disconnected, so that one slow client can't hold up the others. Defaults to 32.
+	BufferSize int
+
+	// ReplaySize is how many of the most recent events are kept for each topic, to be resent to clients that
+	// reconnect with a Last-Event-ID. Defaults to 100. Set it to -1 to keep none.
+	ReplaySize int
+}
+
+// EventBroker fans out events published to topics to every EventStream subscribed to those topics.
+// Publish is safe to call from any goroutine.
+type EventBroker struct {
+	options EventBrokerOptions
+
+	mu     sync.Mutex
+	seq    uint64
+	topics map[string]*brokerTopic
+}
+
+type brokerTopic struct {
+	subscribers map[*brokerSubscriber]struct{}
+	history     []brokerEvent // oldest first
+}
+
+type brokerEvent struct {
+	seq   uint64
+	event Event
+}
+
+type brokerSubscriber struct {
+	events  chan Event
+	topics  []string
+	evicted bool
+}
+
+// NewEventBroker returns a new broker.
+func NewEventBroker(options EventBrokerOptions) *EventBroker {
+	if options.BufferSize <= 0 {
+		options.BufferSize = 32
+	}
+	if options.ReplaySize == 0 {
+		options.ReplaySize = 100
+	}
+	return &EventBroker{options: options, topics: make(map[string]*brokerTopic)}
+}
+
+// Publish sends e to every client subscribed to topic, and keeps it for replay. If e.ID is empty, it's given
+// an ID that's unique within the broker.
+func (b *EventBroker) Publish(topic string, e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.seq++
+	if e.ID == "" {
+		e.ID = strconv.FormatUint(b.seq, 10)
+	}
+
+	t := b.topics[topic]
+	if b.options.ReplaySize > 0 {
+		t = b.topic(topic)
+		if len(t.history) == b.options.ReplaySize {
+			copy(t.history, t.history[1:])
+			t.history = t.history[:len(t.history)-1]
+		}
+		t.history = append(t.history, brokerEvent{seq: b.seq, event: e})
+	} else if t == nil {
+		// Without history to keep, a topic nobody's subscribed to isn't created, so publishing can't leak topics.
+		return
+	}
+
+	for sub := range t.subscribers {
+		select {
+		case sub.events <- e:
+		default:
+			b.evict(sub)
+		}
+	}
+}
+
+// Subscribe streams events published to any of topics to stream until the client disconnects. If the client
+// is reconnecting with a Last-Event-ID that the broker still remembers, the events it missed are sent first;
+// if the ID is too old to be remembered, every retained event is sent.
+//
+// It returns ErrSlowConsumer if the client was disconnected because its buffer filled up, or the error
+// that ended the stream otherwise.
+func (b *EventBroker) Subscribe(stream *EventStream, topics ...string) error {
+	sub := &brokerSubscriber{events: make(chan Event, b.options.BufferSize), topics: topics}
+
+	b.mu.Lock()
+	replay := b.missed(stream.LastEventID(), topics)
+	for _, topic := range topics {
+		b.topic(topic).subscribers[sub] = struct{}{}
+	}
+	b.mu.Unlock()
+
+	defer func() {
+		b.mu.Lock()
+		if !sub.evicted {
+			b.unsubscribe(sub)
+		}
+		b.mu.Unlock()
+	}()
+
+	for _, e := range replay {
+		if err := stream.Send(e); err != nil {
+			return err
+		}
+	}
+
+	// Stream returns nil once the subscriber's channel is closed, which only happens when it's evicted.
+	if err := stream.Stream(sub.events); err != nil {
+		return err
+	}
+	return ErrSlowConsumer
+}
+
+// Subscribers returns how many clients are subscribed to topic.
+func (b *EventBroker) Subscribers(topic string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if t, ok := b.topics[topic]; ok {
+		return len(t.subscribers)
+	}
+	return 0
+}
+
+// topic returns the named topic, creating it if needed. b.mu must be held.
+func (b *EventBroker) topic(name string) *brokerTopic {
+	t, ok := b.topics[name]
+	if !ok {
+		t = &brokerTopic{subscribers: make(map[*brokerSubscriber]struct{})}
+		b.topics[name] = t
+	}
+	return t
+}
+
+// missed returns the retained events in topics published after the event with ID lastEventID, in order. b.mu must be held.
+func (b *EventBroker) missed(lastEventID string, topics []string) []Event {
+	if lastEventID == "" {
+		return nil
+	}
+
+	var since uint64
+	var candidates []brokerEvent
+	for _, topic := range topics {
+		t, ok := b.topics[topic]
+		if !ok {
+			continue
+		}
+		for _, be := range t.history {
+			if be.event.ID == lastEventID {
+				since = be.seq
+			}
+		}
+		candidates = append(candidates, t.history...)
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].seq < candidates[j].seq })
+
+	var events []Event
+	for _, be := range candidates {
+		if be.seq > since {
+			events = append(events, be.event)
+		}
+	}
+	return events
+}
+
+// evict disconnects a subscriber that can't keep up. b.mu must be held.
+func (b *EventBroker) evict(sub *brokerSubscriber) {
+	sub.evicted = true
+	b.unsubscribe(sub)
+	close(sub.events)
+}
+
+// unsubscribe removes sub from its topics, and drops topics that have neither subscribers nor history. b.mu must be held.
+func (b *EventBroker) unsubscribe(sub *brokerSubscriber) {
+	for _, topic := range sub.topics {
+		t, ok := b.topics[topic]
+		if !ok {
+			continue
+		}
+		delete(t.subscribers, sub)
+		if len(t.subscribers) == 0 && len(t.history) == 0 {
+			delete(b.topics, topic)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package web
+
+import (
+	"bufio"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readEvents reads n events from an event stream response, returning their "id/data" pairs.
+func readEvents(t *testing.T, reader *bufio.Reader, n int) []string {
+	var events []string
+	var id, data string
+	for len(events) < n {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		line = strings.TrimRight(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "id: "):
+			id = line[4:]
+		case strings.HasPrefix(line, "data: "):
+			data = line[6:]
+		case line == "" && data != "":
+			events = append(events, id+"/"+data)
+			id, data = "", ""
+		}
+	}
+	return events
+}
+
+func newBrokerServer(broker *EventBroker, subscribeErr chan error) *httptest.Server {
+	router := New(Context{})
+	router.Get("/events/:topic", func(rw ResponseWriter, req *Request) {
+		subscribeErr <- broker.Subscribe(NewEventStream(rw, req), strings.Split(req.PathParams["topic"], ",")...)
+	})
+	return httptest.NewServer(router)
+}
+
+func waitForSubscribers(broker *EventBroker, topic string, n int) {
+	for i := 0; i < 200 && broker.Subscribers(topic) != n; i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestEventBrokerPublishAndReplay(t *testing.T) {
+	broker := NewEventBroker(EventBrokerOptions{ReplaySize: 3})
+	subscribeErr := make(chan error, 10)
+	server := newBrokerServer(broker, subscribeErr)
+	defer server.Close()
+
+	broker.Publish("builds", Event{Data: "b1"})
+	broker.Publish("deploys", Event{Data: "d2"})
+	broker.Publish("builds", Event{Data: "b3"})
+	broker.Publish("builds", Event{Data: "b4"})
+	broker.Publish("builds", Event{Data: "b5"})
+
+	// A client reconnecting after event 3 gets the retained events it missed, across its topics:
+	req, _ := http.NewRequest("GET", server.URL+"/events/builds,deploys", nil)
+	req.Header.Set("Last-Event-ID", "3")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := bufio.NewReader(resp.Body)
+	assert.Equal(t, []string{"4/b4", "5/b5"}, readEvents(t, reader, 2))
+
+	// New events arrive as they're published:
+	waitForSubscribers(broker, "builds", 1)
+	broker.Publish("deploys", Event{ID: "custom", Data: "d6"})
+	broker.Publish("other", Event{Data: "o7"})
+	broker.Publish("builds", Event{Data: "b8"})
+	assert.Equal(t, []string{"custom/d6", "8/b8"}, readEvents(t, reader, 2))
+
+	// Unsubscribe when the client goes away:
+	resp.Body.Close()
+	assert.Error(t, <-subscribeErr)
+	waitForSubscribers(broker, "builds", 0)
+	assert.Equal(t, 0, broker.Subscribers("builds"))
+
+	// An unknown ID replays everything retained:
+	req, _ = http.NewRequest("GET", server.URL+"/events/builds", nil)
+	req.Header.Set("Last-Event-ID", "ancient")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, []string{"4/b4", "5/b5", "8/b8"}, readEvents(t, bufio.NewReader(resp.Body), 3))
+}
+
+func TestEventBrokerWithoutReplayDoesntKeepTopics(t *testing.T) {
+	broker := NewEventBroker(EventBrokerOptions{ReplaySize: -1})
+	for i := 0; i < 1000; i++ {
+		broker.Publish("topic-"+strconv.Itoa(i), Event{Data: "x"})
+	}
+	assert.Empty(t, broker.topics)
+
+	sub := &brokerSubscriber{events: make(chan Event, 1), topics: []string{"builds"}}
+	broker.topic("builds").subscribers[sub] = struct{}{}
+	broker.Publish("builds", Event{Data: "1"})
+	e := <-sub.events
+	assert.Equal(t, "1", e.Data)
+	broker.unsubscribe(sub)
+	assert.Empty(t, broker.topics)
+}
+
+func TestEventBrokerSlowConsumer(t *testing.T) {
+	broker := NewEventBroker(EventBrokerOptions{BufferSize: 1})
+	sub := &brokerSubscriber{events: make(chan Event, 1), topics: []string{"builds"}}
+	broker.topic("builds").subscribers[sub] = struct{}{}
+
+	broker.Publish("builds", Event{Data: "1"})
+	assert.Equal(t, 1, broker.Subscribers("builds"))
+
+	// The buffer is full, so the subscriber is evicted, but it still gets what was buffered:
+	broker.Publish("builds", Event{Data: "2"})
+	assert.Equal(t, 0, broker.Subscribers("builds"))
+	assert.True(t, sub.evicted)
+	e, ok := <-sub.events
+	assert.True(t, ok)
+	assert.Equal(t, "1", e.Data)
+	_, ok = <-sub.events
+	assert.False(t, ok)
+
+	// Subscribe reports the eviction:
+	subscribeErr := make(chan error, 1)
+	server := newBrokerServer(broker, subscribeErr)
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/events/builds")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	waitForSubscribers(broker, "builds", 1)
+	for i := 0; i < 100; i++ {
+		broker.Publish("builds", Event{Data: strings.Repeat("x", 1<<16)})
+	}
+	select {
+	case err := <-subscribeErr:
+		assert.Equal(t, ErrSlowConsumer, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("slow consumer wasn't evicted")
+	}
+}
@@ -0,0 +1,151 @@
+package web
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultKeepAliveInterval is how often an EventStream sends a keep-alive comment while Stream is idle.
+var DefaultKeepAliveInterval = 15 * time.Second
+
+// Event is a single Server-Sent Event. Only Data is required.
+type Event struct {
+	// ID becomes the client's last event ID, which it sends back in the Last-Event-ID header when it reconnects.
+	ID string
+	// Event is the event type. Clients listen for it with addEventListener. If empty, it's a "message" event.
+	Event string
+	// Data is the payload. It may span multiple lines.
+	Data string
+	// Retry, if set, tells the client how long to wait before reconnecting.
+	Retry time.Duration
+}
+
+// EventStream writes Server-Sent Events (text/event-stream) to a response.
+type EventStream struct {
+	// KeepAlive is how often Stream sends a comment to keep idle connections open. It defaults to DefaultKeepAliveInterval.
+	KeepAlive time.Duration
+
+	rw  ResponseWriter
+	req *Request
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+// NewEventStream writes the headers of an event stream and returns it. Call Send or Stream from your handler
+// to write events. The stream ends when your handler returns or the client disconnects.
+func NewEventStream(rw ResponseWriter, req *Request) *EventStream {
+	header := rw.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("X-Accel-Buffering", "no") // Stop nginx from buffering the stream.
+	rw.WriteHeader(http.StatusOK)
+	rw.Flush()
+
+	return &EventStream{KeepAlive: DefaultKeepAliveInterval, rw: rw, req: req}
+}
+
+// LastEventID returns the ID of the last event the client received, if it's reconnecting. Use it to resend missed events.
+func (s *EventStream) LastEventID() string {
+	return s.req.Header.Get("Last-Event-ID")
+}
+
+// Done is closed when the client disconnects.
+func (s *EventStream) Done() <-chan struct{} {
+	return s.req.Context().Done()
+}
+
+// Send writes an event and flushes it to the client. It returns an error if the client has disconnected.
+// It's safe to call from multiple goroutines while your handler is running.
+func (s *EventStream) Send(e Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.buf.Reset()
+	if e.ID != "" {
+		writeEventField(&s.buf, "id", e.ID)
+	}
+	if e.Event != "" {
+		writeEventField(&s.buf, "event", e.Event)
+	}
+	if e.Retry > 0 {
+		writeEventField(&s.buf, "retry", strconv.FormatInt(int64(e.Retry/time.Millisecond), 10))
+	}
+	for _, line := range splitEventLines(e.Data) {
+		writeEventField(&s.buf, "data", line)
+	}
+	s.buf.WriteByte('\n')
+	return s.flush()
+}
+
+// Comment writes a comment line, which clients ignore. It's useful for keeping connections open.
+func (s *EventStream) Comment(text string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.buf.Reset()
+	for _, line := range splitEventLines(text) {
+		s.buf.WriteString(": ")
+		s.buf.WriteString(line)
+		s.buf.WriteByte('\n')
+	}
+	s.buf.WriteByte('\n')
+	return s.flush()
+}
+
+// Stream sends each event received from events, and a keep-alive comment whenever the stream has been idle
+// for KeepAlive. It returns nil once events is closed, or an error when the client disconnects.
+func (s *EventStream) Stream(events <-chan Event) error {
+	keepAlive := time.NewTicker(s.KeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		var err error
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return nil
+			}
+			err = s.Send(e)
+			keepAlive.Reset(s.KeepAlive)
+		case <-keepAlive.C:
+			err = s.Comment("keep-alive")
+		case <-s.Done():
+			err = s.req.Context().Err()
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *EventStream) flush() error {
+	if err := s.req.Context().Err(); err != nil {
+		return err
+	}
+	if _, err := s.rw.Write(s.buf.Bytes()); err != nil {
+		return err
+	}
+	s.rw.Flush()
+	return nil
+}
+
+var eventFieldReplacer = strings.NewReplacer("\r", "", "\n", "")
+
+// writeEventField writes "name: value\n". Newlines in value would end the field early, so they're dropped.
+func writeEventField(buf *bytes.Buffer, name, value string) {
+	buf.WriteString(name)
+	buf.WriteString(": ")
+	buf.WriteString(eventFieldReplacer.Replace(value))
+	buf.WriteByte('\n')
+}
+
+// splitEventLines splits s on any of the line endings the event stream format allows: \r\n, \n and \r.
+func splitEventLines(s string) []string {
+	s = strings.Replace(s, "\r\n", "\n", -1)
+	s = strings.Replace(s, "\r", "\n", -1)
+	return strings.Split(s, "\n")
+}
@@ -0,0 +1,68 @@
+package web
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestEventStreamSend(t *testing.T) {
+	router := New(Context{})
+	router.Get("/events", func(rw ResponseWriter, req *Request) {
+		stream := NewEventStream(rw, req)
+		assert.Equal(t, "41", stream.LastEventID())
+		assert.NoError(t, stream.Send(Event{ID: "42", Event: "build", Data: "line 1\nline 2\r\nline 3", Retry: 3 * time.Second}))
+		assert.NoError(t, stream.Send(Event{Data: "plain", ID: "bad\nid"}))
+		assert.NoError(t, stream.Comment("hi"))
+	})
+
+	rw, req := newTestRequest("GET", "/events")
+	req.Header.Set("Last-Event-ID", "41")
+	router.ServeHTTP(rw, req)
+
+	assert.Equal(t, 200, rw.Code)
+	assert.Equal(t, "text/event-stream", rw.Header().Get("Content-Type"))
+	assert.Equal(t, "no-cache", rw.Header().Get("Cache-Control"))
+	assert.Equal(t, "id: 42\nevent: build\nretry: 3000\ndata: line 1\ndata: line 2\ndata: line 3\n\n"+
+		"id: badid\ndata: plain\n\n"+
+		": hi\n\n", rw.Body.String())
+	assert.True(t, rw.Flushed)
+}
+
+func TestEventStreamStream(t *testing.T) {
+	events := make(chan Event)
+	streamErr := make(chan error, 1)
+
+	router := New(Context{})
+	router.Get("/events", func(rw ResponseWriter, req *Request) {
+		stream := NewEventStream(rw, req)
+		stream.KeepAlive = 10 * time.Millisecond
+		streamErr <- stream.Stream(events)
+	})
+
+	// Events are sent until the channel is closed:
+	rw, req := newTestRequest("GET", "/events")
+	go func() {
+		events <- Event{Data: "one"}
+		time.Sleep(50 * time.Millisecond)
+		events <- Event{Data: "two"}
+		close(events)
+	}()
+	router.ServeHTTP(rw, req)
+	assert.NoError(t, <-streamErr)
+	body := rw.Body.String()
+	assert.Contains(t, body, "data: one\n\n: keep-alive\n\n")
+	assert.Contains(t, body, "data: two\n\n")
+
+	// The stream stops when the client disconnects:
+	events = make(chan Event)
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ = http.NewRequest("GET", "/events", nil)
+	req = req.WithContext(ctx)
+	time.AfterFunc(20*time.Millisecond, cancel)
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, context.Canceled, <-streamErr)
+}
@@ -0,0 +1,314 @@
+package web
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// NewOverlayFileSystem returns an http.FileSystem that layers several file systems on top of each other, eg a
+// customer's theme over the default theme. Opening a file opens it from the first layer that has it. Directories
+// that exist in several layers are merged, so listing one (eg, with StaticOption.DirectoryListing) shows the
+// files of every layer, with the first layer's winning when names clash.
+//
+// To use an fs.FS (eg, one returned by NewZipFS or NewTarFS) as a layer, wrap it with http.FS.
+func NewOverlayFileSystem(layers ...http.FileSystem) http.FileSystem {
+	return overlayFileSystem(layers)
+}
+
+type overlayFileSystem []http.FileSystem
+
+func (o overlayFileSystem) Open(name string) (http.File, error) {
+	var dirs []http.File
+	var firstErr error
+	for _, layer := range o {
+		f, err := layer.Open(name)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		fi, err := f.Stat()
+		if err != nil {
+			f.Close()
+			continue
+		}
+		if !fi.IsDir() {
+			if len(dirs) == 0 {
+				return f, nil
+			}
+			// A file under a directory of an earlier layer is hidden by it.
+			f.Close()
+			continue
+		}
+		dirs = append(dirs, f)
+	}
+
+	switch len(dirs) {
+	case 0:
+		if firstErr == nil {
+			firstErr = &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+		}
+		return nil, firstErr
+	case 1:
+		return dirs[0], nil
+	}
+	return &overlayDir{File: dirs[0], layers: dirs}, nil
+}
+
+// overlayDir is a directory that exists in more than one layer. Everything but Readdir and Close is answered
+// by the first layer's directory.
+type overlayDir struct {
+	http.File
+	layers  []http.File
+	entries []fs.FileInfo // nil until the first Readdir
+	offset  int
+}
+
+func (d *overlayDir) Close() error {
+	var err error
+	for _, f := range d.layers {
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+func (d *overlayDir) Readdir(count int) ([]fs.FileInfo, error) {
+	if d.entries == nil {
+		d.entries = []fs.FileInfo{}
+		seen := make(map[string]bool)
+		for _, f := range d.layers {
+			infos, err := f.Readdir(-1)
+			if err != nil {
+				return nil, err
+			}
+			for _, fi := range infos {
+				if !seen[fi.Name()] {
+					seen[fi.Name()] = true
+					d.entries = append(d.entries, fi)
+				}
+			}
+		}
+		sort.Slice(d.entries, func(i, j int) bool { return d.entries[i].Name() < d.entries[j].Name() })
+	}
+
+	rest := d.entries[d.offset:]
+	if count <= 0 {
+		d.offset = len(d.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(rest) {
+		count = len(rest)
+	}
+	d.offset += count
+	return rest[:count], nil
+}
+
+// OpenZipFS reads the zip archive at name as an fs.FS, eg for StaticMiddlewareFromFS. Since an archive can't
+// change, use StaticOption.Immutable to serve its files with ETags.
+func OpenZipFS(name string) (fs.FS, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return NewZipFS(f, fi.Size())
+}
+
+// NewZipFS reads the zip archive in r, which is size bytes long, into memory and returns its files as an fs.FS.
+// (http.ServeContent needs to seek in files, which zip's own fs.FS can't do for compressed files.)
+func NewZipFS(r io.ReaderAt, size int64) (fs.FS, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	mfs := memFS{".": &memFile{name: ".", mode: fs.ModeDir | 0755}}
+	for _, zf := range zr.File {
+		name := path.Clean(strings.TrimPrefix(zf.Name, "/"))
+		if name == "." || !fs.ValidPath(name) {
+			continue
+		}
+		fi := zf.FileInfo()
+		if fi.IsDir() {
+			mfs.add(name, nil, fi.Mode(), zf.Modified)
+			continue
+		}
+		if !fi.Mode().IsRegular() {
+			continue
+		}
+		f, err := zf.Open()
+		if err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		mfs.add(name, data, fi.Mode(), zf.Modified)
+	}
+	return mfs, nil
+}
+
+// NewTarFS reads the tar archive in r, which may be gzipped, into memory and returns its files as an fs.FS.
+// Only regular files and directories are kept; links and other special files are skipped.
+func NewTarFS(r io.Reader) (fs.FS, error) {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	} else {
+		r = br
+	}
+
+	mfs := memFS{".": &memFile{name: ".", mode: fs.ModeDir | 0755}}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return mfs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		name := path.Clean(strings.TrimPrefix(hdr.Name, "/"))
+		if name == "." || !fs.ValidPath(name) {
+			continue
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			mfs.add(name, nil, hdr.FileInfo().Mode(), hdr.ModTime)
+		case tar.TypeReg:
+			data, err := ioutil.ReadAll(tr)
+			if err != nil {
+				return nil, err
+			}
+			mfs.add(name, data, hdr.FileInfo().Mode(), hdr.ModTime)
+		}
+	}
+}
+
+// memFS is a read-only, in-memory fs.FS, keyed by path. Every directory is present, including ".".
+type memFS map[string]*memFile
+
+// add adds a file or directory, and any parent directories that are missing.
+func (m memFS) add(name string, data []byte, mode fs.FileMode, modTime time.Time) {
+	if existing, ok := m[name]; ok {
+		// Either a directory created implicitly by one of its files, whose real mode and time we now know,
+		// or a file that appears in the archive more than once, where the last copy wins.
+		existing.data, existing.mode, existing.modTime = data, mode, modTime
+		return
+	}
+	m[name] = &memFile{name: name, data: data, mode: mode, modTime: modTime}
+
+	dir := path.Dir(name)
+	if _, ok := m[dir]; !ok {
+		m.add(dir, nil, fs.ModeDir|0755, modTime)
+	}
+	parent := m[dir]
+	parent.children = append(parent.children, name)
+}
+
+func (m memFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	f, ok := m[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if f.IsDir() {
+		entries := make([]fs.DirEntry, len(f.children))
+		for i, child := range f.children {
+			entries[i] = m[child]
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+		return &memDir{memFile: f, entries: entries}, nil
+	}
+	return &openMemFile{memFile: f, Reader: bytes.NewReader(f.data)}, nil
+}
+
+// memFile is a file or directory of a memFS. It's its own fs.FileInfo and fs.DirEntry.
+type memFile struct {
+	name     string
+	data     []byte
+	mode     fs.FileMode
+	modTime  time.Time
+	children []string // for directories, the paths of their entries
+}
+
+func (f *memFile) Name() string               { return path.Base(f.name) }
+func (f *memFile) Size() int64                { return int64(len(f.data)) }
+func (f *memFile) Mode() fs.FileMode          { return f.mode }
+func (f *memFile) ModTime() time.Time         { return f.modTime }
+func (f *memFile) IsDir() bool                { return f.mode.IsDir() }
+func (f *memFile) Sys() interface{}           { return nil }
+func (f *memFile) Type() fs.FileMode          { return f.mode.Type() }
+func (f *memFile) Info() (fs.FileInfo, error) { return f, nil }
+
+type openMemFile struct {
+	*memFile
+	*bytes.Reader
+}
+
+func (f *openMemFile) Stat() (fs.FileInfo, error) { return f.memFile, nil }
+func (f *openMemFile) Close() error               { return nil }
+
+// Size resolves the ambiguity between memFile.Size and bytes.Reader.Size.
+func (f *openMemFile) Size() int64 { return f.memFile.Size() }
+
+type memDir struct {
+	*memFile
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *memDir) Stat() (fs.FileInfo, error) { return d.memFile, nil }
+func (d *memDir) Close() error               { return nil }
+
+func (d *memDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: fs.ErrInvalid}
+}
+
+func (d *memDir) ReadDir(count int) ([]fs.DirEntry, error) {
+	rest := d.entries[d.offset:]
+	if count <= 0 {
+		d.offset = len(d.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(rest) {
+		count = len(rest)
+	}
+	d.offset += count
+	return rest[:count], nil
+}
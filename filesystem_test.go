@@ -0,0 +1,166 @@
+package web
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/fs"
+	"net/http"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestOverlayFileSystem(t *testing.T) {
+	customer := fstest.MapFS{
+		"css/site.css":  {Data: []byte("customer css")},
+		"logo.png":      {Data: []byte("customer logo")},
+		"fonts":         {Data: []byte("a file hiding the default fonts directory")},
+		"images/a.png":  {Data: []byte("customer a")},
+		"images/c.png":  {Data: []byte("customer c")},
+		"custom/x.html": {Data: []byte("x")},
+	}
+	defaults := fstest.MapFS{
+		"css/site.css": {Data: []byte("default css")},
+		"css/base.css": {Data: []byte("default base")},
+		"logo.png":     {Data: []byte("default logo")},
+		"fonts/a.woff": {Data: []byte("font")},
+		"images/a.png": {Data: []byte("default a")},
+		"images/b.png": {Data: []byte("default b")},
+		"index.html":   {Data: []byte("default index")},
+	}
+	overlay := NewOverlayFileSystem(http.FS(customer), http.FS(defaults))
+
+	router := New(Context{})
+	router.Middleware(StaticMiddlewareFromDir(overlay, StaticOption{IndexFile: "index.html", DirectoryListing: true}))
+
+	for path, body := range map[string]string{
+		"/css/site.css":  "customer css",
+		"/css/base.css":  "default base",
+		"/logo.png":      "customer logo",
+		"/fonts":         "a file hiding the default fonts directory",
+		"/custom/x.html": "x",
+		"/":              "default index",
+	} {
+		rw, req := newTestRequest("GET", path)
+		router.ServeHTTP(rw, req)
+		assertResponse(t, rw, body, 200)
+	}
+
+	// Directories in both layers are merged:
+	rw, req := newTestRequest("GET", "/images/")
+	req.Header.Set("Accept", "application/json")
+	router.ServeHTTP(rw, req)
+	assert.Contains(t, rw.Body.String(), `"name":"a.png","isDir":false,"size":10`)
+	assert.Equal(t, 3, strings.Count(rw.Body.String(), `"name"`))
+
+	// Readdir pages through the merged entries:
+	dir, err := overlay.Open("/images")
+	if assert.NoError(t, err) {
+		defer dir.Close()
+		var names []string
+		for {
+			infos, err := dir.Readdir(2)
+			for _, fi := range infos {
+				names = append(names, fi.Name())
+			}
+			if err == io.EOF {
+				break
+			}
+			assert.NoError(t, err)
+		}
+		assert.Equal(t, []string{"a.png", "b.png", "c.png"}, names)
+	}
+
+	_, err = overlay.Open("/missing")
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+}
+
+func TestTarFS(t *testing.T) {
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, f := range []struct {
+		name, body string
+		typeflag   byte
+	}{
+		{"./css/site.css", "body {}", tar.TypeReg},
+		{"./css/", "", tar.TypeDir},
+		{"./index.html", "home", tar.TypeReg},
+		{"./link.html", "", tar.TypeSymlink},
+		{"./index.html", "newer home", tar.TypeReg},
+	} {
+		tw.WriteHeader(&tar.Header{Name: f.name, Typeflag: f.typeflag, Size: int64(len(f.body)), Mode: 0644, ModTime: modTime, Linkname: "index.html"})
+		tw.Write([]byte(f.body))
+	}
+	tw.Close()
+	gz.Close()
+
+	fsys, err := NewTarFS(&buf)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, fstest.TestFS(fsys, "css/site.css", "index.html"))
+
+	data, err := fs.ReadFile(fsys, "index.html")
+	assert.NoError(t, err)
+	assert.Equal(t, "newer home", string(data))
+	_, err = fs.Stat(fsys, "link.html")
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+
+	router := New(Context{})
+	router.Middleware(StaticMiddlewareFromFS(fsys, StaticOption{IndexFile: "index.html", Immutable: true}))
+	rw, req := newTestRequest("GET", "/css/site.css")
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "body {}", 200)
+	assert.Equal(t, "text/css; charset=utf-8", rw.Header().Get("Content-Type"))
+	assert.Equal(t, modTime.Format(http.TimeFormat), rw.Header().Get("Last-Modified"))
+	assert.NotEqual(t, "", rw.Header().Get("ETag"))
+
+	rw, req = newTestRequest("GET", "/")
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "newer home", 200)
+
+	// Uncompressed archives work too:
+	buf.Reset()
+	tw = tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "a/b/c.txt", Typeflag: tar.TypeReg, Size: 1, Mode: 0644})
+	tw.Write([]byte("c"))
+	tw.Close()
+	fsys, err = NewTarFS(&buf)
+	assert.NoError(t, err)
+	assert.NoError(t, fstest.TestFS(fsys, "a/b/c.txt"))
+}
+
+func TestZipFS(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, _ := zw.Create("js/app.js")
+	w.Write([]byte("alert(1)"))
+	zw.Close()
+
+	fsys, err := NewZipFS(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, fstest.TestFS(fsys, "js/app.js"))
+
+	// Archives can be layered under a directory of overrides:
+	overrides := fstest.MapFS{"js/extra.js": {Data: []byte("extra")}}
+	router := New(Context{})
+	router.Middleware(StaticMiddlewareFromDir(NewOverlayFileSystem(http.FS(overrides), http.FS(fsys))))
+
+	rw, req := newTestRequest("GET", "/js/app.js")
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "alert(1)", 200)
+
+	rw, req = newTestRequest("GET", "/js/extra.js")
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "extra", 200)
+}
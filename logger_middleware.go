@@ -28,5 +28,9 @@ func LoggerMiddleware(rw ResponseWriter, req *Request, next NextMiddlewareFunc)
 		durationUnits = "ns"
 	}
 
-	Logger.Printf("[%d %s] %d '%s'\n", duration, durationUnits, rw.StatusCode(), req.URL.Path)
+	if id := req.RequestID(); id != "" {
+		Logger.Printf("[%d %s] %d '%s' %s\n", duration, durationUnits, rw.StatusCode(), req.URL.Path, id)
+	} else {
+		Logger.Printf("[%d %s] %d '%s'\n", duration, durationUnits, rw.StatusCode(), req.URL.Path)
+	}
 }
@@ -0,0 +1,232 @@
+package web
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultLatencyBuckets are the upper bounds, in seconds, of the request duration histogram buckets.
+var DefaultLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// DefaultSizeBuckets are the upper bounds, in bytes, of the response size histogram buckets.
+var DefaultSizeBuckets = []float64{100, 1000, 10000, 100000, 1000000, 10000000}
+
+// MetricsOption configures NewMetrics.
+type MetricsOption struct {
+	// If set, Namespace prefixes the names of the metrics, eg "myapp" gives "myapp_http_requests_total".
+	Namespace string
+
+	// If set, LatencyBuckets replaces DefaultLatencyBuckets.
+	LatencyBuckets []float64
+
+	// If set, SizeBuckets replaces DefaultSizeBuckets.
+	SizeBuckets []float64
+}
+
+// Metrics records request metrics and serves them in the Prometheus text format. Requests are labelled with their
+// method and route (eg, "/users/:id", as returned by RoutePath), not their path, to keep the number of series
+// small. Requests that weren't routed (eg, 404s and static files) have an empty route, and requests with a
+// non-standard method have the method "OTHER". It records:
+//
+//	http_requests_total{method, route, status}: requests served, by status class (eg, "2xx")
+//	http_request_duration_seconds{method, route}: a histogram of request durations
+//	http_response_size_bytes{method, route}: a histogram of response body sizes
+//	http_requests_in_flight: requests being served
+type Metrics struct {
+	prefix         string
+	latencyBuckets []float64
+	sizeBuckets    []float64
+	inFlight       int64
+
+	mu     sync.Mutex
+	series map[metricsKey]*metricsSeries
+}
+
+type metricsKey struct {
+	method, route string
+}
+
+type metricsSeries struct {
+	statuses map[string]uint64 // status class => count
+	latency  histogram
+	size     histogram
+}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative; the last one is +Inf
+	sum    float64
+	count  uint64
+}
+
+func (h *histogram) observe(buckets []float64, v float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(buckets)+1)
+	}
+	h.counts[sort.SearchFloat64s(buckets, v)]++
+	h.sum += v
+	h.count++
+}
+
+// NewMetrics returns an empty Metrics. Add its Middleware to your root router, and serve its Handler on a route
+// (eg, router.Get("/metrics", metrics.Handler)) or another server.
+func NewMetrics(options ...MetricsOption) *Metrics {
+	var option MetricsOption
+	if len(options) > 0 {
+		option = options[0]
+	}
+	m := &Metrics{
+		latencyBuckets: sortedBuckets(option.LatencyBuckets, DefaultLatencyBuckets),
+		sizeBuckets:    sortedBuckets(option.SizeBuckets, DefaultSizeBuckets),
+		series:         make(map[metricsKey]*metricsSeries),
+	}
+	if option.Namespace != "" {
+		m.prefix = option.Namespace + "_"
+	}
+	return m
+}
+
+func sortedBuckets(buckets, defaults []float64) []float64 {
+	if len(buckets) == 0 {
+		buckets = defaults
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return buckets
+}
+
+// Middleware records the metrics of each request. Add it before other middleware so that it times them too.
+// Panics are recorded with the status of the router's response to them.
+func (m *Metrics) Middleware(rw ResponseWriter, req *Request, next NextMiddlewareFunc) {
+	atomic.AddInt64(&m.inFlight, 1)
+	start := time.Now()
+	panicked := true
+	defer func() {
+		atomic.AddInt64(&m.inFlight, -1)
+		if panicked && !rw.Written() {
+			duration := time.Since(start)
+			req.afterPanic(func(err interface{}) {
+				m.observe(metricsMethod(req.Method), req.RoutePath(), responseStatus(rw, true, err), duration, rw.Size())
+			})
+			return
+		}
+		m.observe(metricsMethod(req.Method), req.RoutePath(), responseStatus(rw, false, nil), time.Since(start), rw.Size())
+	}()
+	next(rw, req)
+	panicked = false
+}
+
+// metricsMethod returns method if it's a standard one, and "OTHER" if not, so that clients can't create a series for
+// every method they make up.
+func metricsMethod(method string) string {
+	switch method {
+	case "GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS", "CONNECT", "TRACE":
+		return method
+	}
+	return "OTHER"
+}
+
+func (m *Metrics) observe(method, route string, status int, duration time.Duration, size int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := metricsKey{method: method, route: route}
+	s := m.series[key]
+	if s == nil {
+		s = &metricsSeries{statuses: make(map[string]uint64)}
+		m.series[key] = s
+	}
+	s.statuses[strconv.Itoa(status/100)+"xx"]++
+	s.latency.observe(m.latencyBuckets, duration.Seconds())
+	s.size.observe(m.sizeBuckets, float64(size))
+}
+
+// Handler serves the metrics in the Prometheus text exposition format.
+func (m *Metrics) Handler(rw ResponseWriter, req *Request) {
+	m.ServeHTTP(rw, req.Request)
+}
+
+// ServeHTTP serves the metrics in the Prometheus text exposition format.
+func (m *Metrics) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w := bufio.NewWriter(rw)
+	m.write(w)
+	w.Flush()
+}
+
+func (m *Metrics) write(w *bufio.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := make([]metricsKey, 0, len(m.series))
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].route != keys[j].route {
+			return keys[i].route < keys[j].route
+		}
+		return keys[i].method < keys[j].method
+	})
+
+	name := m.prefix + "http_requests_total"
+	fmt.Fprintf(w, "# HELP %s Total number of HTTP requests served.\n# TYPE %s counter\n", name, name)
+	for _, key := range keys {
+		statuses := m.series[key].statuses
+		classes := make([]string, 0, len(statuses))
+		for class := range statuses {
+			classes = append(classes, class)
+		}
+		sort.Strings(classes)
+		for _, class := range classes {
+			fmt.Fprintf(w, "%s{%s,status=%s} %d\n", name, key.labels(), quoteLabel(class), statuses[class])
+		}
+	}
+
+	name = m.prefix + "http_request_duration_seconds"
+	fmt.Fprintf(w, "# HELP %s Duration of HTTP requests in seconds.\n# TYPE %s histogram\n", name, name)
+	for _, key := range keys {
+		writeHistogram(w, name, key.labels(), m.latencyBuckets, &m.series[key].latency)
+	}
+
+	name = m.prefix + "http_response_size_bytes"
+	fmt.Fprintf(w, "# HELP %s Size of HTTP response bodies in bytes.\n# TYPE %s histogram\n", name, name)
+	for _, key := range keys {
+		writeHistogram(w, name, key.labels(), m.sizeBuckets, &m.series[key].size)
+	}
+
+	name = m.prefix + "http_requests_in_flight"
+	fmt.Fprintf(w, "# HELP %s Number of HTTP requests being served.\n# TYPE %s gauge\n", name, name)
+	fmt.Fprintf(w, "%s %d\n", name, atomic.LoadInt64(&m.inFlight))
+}
+
+func writeHistogram(w *bufio.Writer, name, labels string, buckets []float64, h *histogram) {
+	var cumulative uint64
+	for i, bound := range buckets {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", name, labels, formatFloat(bound), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, h.count)
+	fmt.Fprintf(w, "%s_sum{%s} %s\n", name, labels, formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, h.count)
+}
+
+func (k metricsKey) labels() string {
+	return "method=" + quoteLabel(k.method) + ",route=" + quoteLabel(k.route)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func quoteLabel(v string) string {
+	return `"` + labelEscaper.Replace(v) + `"`
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,109 @@
+package web
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	metrics := NewMetrics(MetricsOption{Namespace: "app", LatencyBuckets: []float64{1, 0.1}, SizeBuckets: []float64{5, 50}})
+	router := New(Context{})
+	router.Middleware(metrics.Middleware)
+	router.Get("/users/:id", (*Context).A)
+	router.Get("/boom", (*Context).ErrorAction)
+	router.Get("/invalid", func(rw ResponseWriter, req *Request) { panic(&BindError{}) })
+	router.Get("/metrics", metrics.Handler)
+
+	for _, path := range []string{"/users/1", "/users/2", "/boom", "/invalid", "/missing"} {
+		rw, req := newTestRequest("GET", path)
+		router.ServeHTTP(rw, req)
+	}
+
+	// Made up methods share a series:
+	for _, method := range []string{"FOO1", "FOO2"} {
+		rw, req := newTestRequest(method, "/users/1")
+		router.ServeHTTP(rw, req)
+	}
+
+	rw, req := newTestRequest("GET", "/metrics")
+	router.ServeHTTP(rw, req)
+	assert.Equal(t, 200, rw.Code)
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", rw.Header().Get("Content-Type"))
+	body := rw.Body.String()
+
+	for _, line := range []string{
+		"# TYPE app_http_requests_total counter",
+		`app_http_requests_total{method="GET",route="",status="4xx"} 1`,
+		`app_http_requests_total{method="OTHER",route="",status="4xx"} 2`,
+		`app_http_requests_total{method="GET",route="/boom",status="5xx"} 1`,
+		`app_http_requests_total{method="GET",route="/invalid",status="4xx"} 1`,
+		`app_http_requests_total{method="GET",route="/users/:id",status="2xx"} 2`,
+		"# TYPE app_http_request_duration_seconds histogram",
+		`app_http_request_duration_seconds_bucket{method="GET",route="/users/:id",le="0.1"} 2`,
+		`app_http_request_duration_seconds_bucket{method="GET",route="/users/:id",le="1"} 2`,
+		`app_http_request_duration_seconds_bucket{method="GET",route="/users/:id",le="+Inf"} 2`,
+		`app_http_request_duration_seconds_count{method="GET",route="/users/:id"} 2`,
+		"# TYPE app_http_response_size_bytes histogram",
+		`app_http_response_size_bytes_bucket{method="GET",route="/users/:id",le="5"} 0`,
+		`app_http_response_size_bytes_bucket{method="GET",route="/users/:id",le="50"} 2`,
+		`app_http_response_size_bytes_sum{method="GET",route="/users/:id"} 18`,
+		"# TYPE app_http_requests_in_flight gauge",
+		// The request for /metrics itself is in flight:
+		"app_http_requests_in_flight 1",
+	} {
+		assert.Contains(t, body, line+"\n")
+	}
+
+	// The scrape shows up next time:
+	rw, req = newTestRequest("GET", "/metrics")
+	router.ServeHTTP(rw, req)
+	assert.Contains(t, rw.Body.String(), `app_http_requests_total{method="GET",route="/metrics",status="2xx"} 1`)
+}
+
+func TestMetricsPanicsRecoveredByMiddleware(t *testing.T) {
+	metrics := NewMetrics(MetricsOption{})
+	router := New(Context{})
+	router.Middleware(ShowErrorsMiddleware)
+	router.Middleware(metrics.Middleware)
+	router.Get("/boom", (*Context).ErrorAction)
+	router.Get("/metrics", metrics.Handler)
+
+	rw, req := newTestRequest("GET", "/boom")
+	router.ServeHTTP(rw, req)
+	assert.Equal(t, 500, rw.Code)
+
+	rw, req = newTestRequest("GET", "/metrics")
+	router.ServeHTTP(rw, req)
+	assert.Contains(t, rw.Body.String(), `http_requests_total{method="GET",route="/boom",status="5xx"} 1`)
+	assert.Contains(t, rw.Body.String(), "http_requests_in_flight 1\n")
+}
+
+func TestMetricsHistogram(t *testing.T) {
+	metrics := NewMetrics()
+	metrics.observe("GET", `/a"b`, 200, 30*time.Millisecond, 2000)
+	metrics.observe("GET", `/a"b`, 302, 3*time.Second, 0)
+
+	rw, req := newTestRequest("GET", "/metrics")
+	metrics.ServeHTTP(rw, req)
+	body := rw.Body.String()
+
+	for _, line := range []string{
+		`http_requests_total{method="GET",route="/a\"b",status="2xx"} 1`,
+		`http_requests_total{method="GET",route="/a\"b",status="3xx"} 1`,
+		`http_request_duration_seconds_bucket{method="GET",route="/a\"b",le="0.025"} 0`,
+		`http_request_duration_seconds_bucket{method="GET",route="/a\"b",le="0.05"} 1`,
+		`http_request_duration_seconds_bucket{method="GET",route="/a\"b",le="2.5"} 1`,
+		`http_request_duration_seconds_bucket{method="GET",route="/a\"b",le="5"} 2`,
+		`http_request_duration_seconds_sum{method="GET",route="/a\"b"} 3.03`,
+		`http_response_size_bytes_bucket{method="GET",route="/a\"b",le="100"} 1`,
+		`http_response_size_bytes_bucket{method="GET",route="/a\"b",le="1000"} 1`,
+		`http_response_size_bytes_bucket{method="GET",route="/a\"b",le="10000"} 2`,
+		`http_response_size_bytes_count{method="GET",route="/a\"b"} 2`,
+		"http_requests_in_flight 0",
+	} {
+		assert.Contains(t, body, line+"\n")
+	}
+	assert.False(t, strings.Contains(body, "app_"))
+}
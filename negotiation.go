@@ -0,0 +1,238 @@
+package web
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Negotiate returns the offered media type (eg "application/json") that best matches the request's Accept header,
+// following RFC 7231: the most specific matching media range sets each offer's q-value, the highest q-value wins,
+// and ties go to the earliest offer. It returns the first offer if there's no Accept header, and "" if no offer is acceptable.
+func (r *Request) Negotiate(offers ...string) string {
+	return negotiate(r.Header["Accept"], offers, matchMediaType, 0)
+}
+
+// NegotiateLanguage is like Negotiate, but picks a language tag (eg "en-US") according to Accept-Language.
+// A range matches a tag if it's the tag itself or a prefix of it ending at a '-', so "en" matches "en-US".
+func (r *Request) NegotiateLanguage(offers ...string) string {
+	return negotiate(r.Header["Accept-Language"], offers, matchLanguage, 0)
+}
+
+// NegotiateEncoding is like Negotiate, but picks a content coding (eg "gzip") according to Accept-Encoding.
+// "identity" is acceptable unless it's explicitly refused.
+func (r *Request) NegotiateEncoding(offers ...string) string {
+	return negotiate(r.Header["Accept-Encoding"], offers, matchToken, 1)
+}
+
+// negotiateCompression picks which of offers to compress a response with, or "identity" for none. Unlike
+// NegotiateEncoding, a request without an Accept-Encoding header isn't compressed (RFC 7231, section 5.3.4).
+func (r *Request) negotiateCompression(offers []string) string {
+	if len(r.Header["Accept-Encoding"]) == 0 {
+		return "identity"
+	}
+	return r.NegotiateEncoding(append(offers[:len(offers):len(offers)], "identity")...)
+}
+
+// NegotiateCharset is like Negotiate, but picks a charset (eg "utf-8") according to Accept-Charset.
+func (r *Request) NegotiateCharset(offers ...string) string {
+	return negotiate(r.Header["Accept-Charset"], offers, matchToken, 0)
+}
+
+type acceptRange struct {
+	value  string
+	params map[string]string
+	q      float64
+}
+
+// parseAccept parses the values of an Accept-style header into ranges. Invalid q-values are treated as 0.
+func parseAccept(headers []string) []acceptRange {
+	var ranges []acceptRange
+	for _, header := range headers {
+		for _, part := range strings.Split(header, ",") {
+			fields := strings.Split(part, ";")
+			value := strings.ToLower(strings.TrimSpace(fields[0]))
+			if value == "" {
+				continue
+			}
+			ar := acceptRange{value: value, q: 1}
+			for _, param := range fields[1:] {
+				kv := strings.SplitN(param, "=", 2)
+				if len(kv) != 2 {
+					continue
+				}
+				key := strings.ToLower(strings.TrimSpace(kv[0]))
+				val := strings.Trim(strings.TrimSpace(kv[1]), `"`)
+				if key == "q" {
+					q, err := strconv.ParseFloat(val, 64)
+					if err != nil || q < 0 || q > 1 {
+						q = 0
+					}
+					ar.q = q
+				} else {
+					if ar.params == nil {
+						ar.params = make(map[string]string)
+					}
+					ar.params[key] = strings.ToLower(val)
+				}
+			}
+			ranges = append(ranges, ar)
+		}
+	}
+	return ranges
+}
+
+// negotiate picks the offer with the highest q-value. match reports how specifically a range matches an offer
+// (higher is more specific), or -1 if it doesn't. identityQ is the q-value of "identity" when no range mentions it.
+func negotiate(headers []string, offers []string, match func(ar *acceptRange, offer string) int, identityQ float64) string {
+	if len(offers) == 0 {
+		return ""
+	}
+	if headers == nil {
+		return offers[0]
+	}
+
+	ranges := parseAccept(headers)
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		q, specificity := 0.0, -1
+		lowerOffer := strings.ToLower(offer)
+		for i := range ranges {
+			if s := match(&ranges[i], lowerOffer); s > specificity {
+				q, specificity = ranges[i].q, s
+			}
+		}
+		if specificity < 0 && identityQ > 0 && lowerOffer == "identity" {
+			q = identityQ
+		}
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
+
+func matchMediaType(ar *acceptRange, offer string) int {
+	offerType, offerParams := offer, ""
+	if idx := strings.IndexByte(offer, ';'); idx >= 0 {
+		offerType, offerParams = strings.TrimSpace(offer[:idx]), offer[idx:]
+	}
+
+	var specificity int
+	switch {
+	case ar.value == "*/*":
+		specificity = 0
+	case strings.HasSuffix(ar.value, "/*"):
+		if !strings.HasPrefix(offerType, ar.value[:len(ar.value)-1]) {
+			return -1
+		}
+		specificity = 1
+	case ar.value == offerType:
+		specificity = 2
+	default:
+		return -1
+	}
+
+	// Media range parameters (other than q) must all be present on the offer, and make the match more specific.
+	if len(ar.params) > 0 {
+		offerRange := parseAccept([]string{offerType + offerParams})[0]
+		for k, v := range ar.params {
+			if offerRange.params[k] != v {
+				return -1
+			}
+		}
+		specificity += len(ar.params)
+	}
+	return specificity
+}
+
+func matchLanguage(ar *acceptRange, offer string) int {
+	switch {
+	case ar.value == "*":
+		return 0
+	case ar.value == offer || strings.HasPrefix(offer, ar.value+"-"):
+		return len(ar.value)
+	}
+	return -1
+}
+
+func matchToken(ar *acceptRange, offer string) int {
+	switch {
+	case ar.value == "*":
+		return 0
+	case ar.value == offer:
+		return 1
+	}
+	return -1
+}
+
+// EncoderFunc writes v to w in a particular media type. See RegisterEncoder.
+type EncoderFunc func(w io.Writer, v interface{}) error
+
+type mediaEncoder struct {
+	mediaType string
+	encode    EncoderFunc
+}
+
+var encoders = struct {
+	sync.RWMutex
+	list []mediaEncoder
+}{list: []mediaEncoder{
+	{"application/json", encodeJSON},
+	{"application/xml", encodeXML},
+	{"text/plain", encodeText},
+}}
+
+// RegisterEncoder makes Respond able to render values as mediaType (eg "text/csv"). Encoders registered earlier
+// are preferred when the client accepts several equally. Registering an existing media type replaces its encoder.
+// JSON, XML and plain text encoders are registered by default, in that order.
+func RegisterEncoder(mediaType string, fn EncoderFunc) {
+	encoders.Lock()
+	defer encoders.Unlock()
+	for i := range encoders.list {
+		if encoders.list[i].mediaType == mediaType {
+			encoders.list[i].encode = fn
+			return
+		}
+	}
+	encoders.list = append(encoders.list, mediaEncoder{mediaType, fn})
+}
+
+// Respond renders v with a 200 status in whichever registered media type best matches the request's Accept
+// header (see Negotiate and RegisterEncoder). If none match, it responds with 406 Not Acceptable.
+// Like the other render helpers, encoding errors panic with a *RenderError.
+func Respond(rw ResponseWriter, req *Request, v interface{}) {
+	encoders.RLock()
+	list := append([]mediaEncoder(nil), encoders.list...)
+	encoders.RUnlock()
+	offers := make([]string, len(list))
+	for i, e := range list {
+		offers[i] = e.mediaType
+	}
+
+	rw.Header().Add("Vary", "Accept")
+
+	mediaType := req.Negotiate(offers...)
+	if mediaType == "" {
+		http.Error(rw, http.StatusText(http.StatusNotAcceptable), http.StatusNotAcceptable)
+		return
+	}
+
+	var buf bytes.Buffer
+	for _, e := range list {
+		if e.mediaType == mediaType {
+			if err := e.encode(&buf, v); err != nil {
+				panic(&RenderError{Err: err})
+			}
+			break
+		}
+	}
+	contentType := mediaType
+	if strings.HasPrefix(mediaType, "text/") || mediaType == "application/json" || mediaType == "application/xml" {
+		contentType += "; charset=utf-8"
+	}
+	writeRendered(rw, http.StatusOK, contentType, buf.Bytes())
+}
@@ -0,0 +1,114 @@
+package web
+
+import (
+	"encoding/csv"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newNegotiationRequest(header, value string) *Request {
+	req, _ := http.NewRequest("GET", "/", nil)
+	if header != "" {
+		req.Header.Set(header, value)
+	}
+	return &Request{Request: req}
+}
+
+func TestNegotiate(t *testing.T) {
+	offers := []string{"application/json", "text/csv", "text/html"}
+	for _, tc := range []struct {
+		accept string
+		want   string
+	}{
+		{"", "application/json"},
+		{"text/html", "text/html"},
+		{"text/*, application/json;q=0.5", "text/csv"},
+		{"text/*;q=0.9, text/html, */*;q=0.1", "text/html"},
+		{"text/html;q=0, text/*", "text/csv"},
+		{"TEXT/HTML", "text/html"},
+		{"image/png", ""},
+		{"*/*;q=0", ""},
+		{"application/json;q=0.2, text/csv;q=0.2", "application/json"},
+		{"text/html;level=1", ""},
+	} {
+		req := newNegotiationRequest("Accept", tc.accept)
+		if tc.accept == "" {
+			req.Header.Del("Accept")
+		}
+		assert.Equal(t, tc.want, req.Negotiate(offers...), tc.accept)
+	}
+
+	req := newNegotiationRequest("Accept", "text/html;level=1")
+	assert.Equal(t, "text/html;level=1", req.Negotiate("text/html", "text/html;level=1"))
+}
+
+func TestNegotiateLanguage(t *testing.T) {
+	req := newNegotiationRequest("Accept-Language", "fr-CH, fr;q=0.9, en;q=0.8, *;q=0.5")
+	assert.Equal(t, "fr-CH", req.NegotiateLanguage("en-US", "fr-CH"))
+	assert.Equal(t, "fr-FR", req.NegotiateLanguage("en-US", "fr-FR"))
+	assert.Equal(t, "en-US", req.NegotiateLanguage("de", "en-US"))
+	assert.Equal(t, "de", req.NegotiateLanguage("de"))
+
+	req = newNegotiationRequest("Accept-Language", "en")
+	assert.Equal(t, "", req.NegotiateLanguage("de", "fr"))
+}
+
+func TestNegotiateEncodingAndCharset(t *testing.T) {
+	req := newNegotiationRequest("Accept-Encoding", "gzip;q=0.8, br")
+	assert.Equal(t, "br", req.NegotiateEncoding("gzip", "br", "identity"))
+	assert.Equal(t, "identity", req.NegotiateEncoding("deflate", "identity"))
+
+	req = newNegotiationRequest("Accept-Encoding", "gzip, identity;q=0")
+	assert.Equal(t, "", req.NegotiateEncoding("deflate", "identity"))
+
+	req = newNegotiationRequest("Accept-Encoding", "*;q=0")
+	assert.Equal(t, "", req.NegotiateEncoding("identity"))
+
+	req = newNegotiationRequest("", "")
+	assert.Equal(t, "gzip", req.NegotiateEncoding("gzip", "identity"))
+
+	req = newNegotiationRequest("Accept-Charset", "iso-8859-5, unicode-1-1;q=0.8")
+	assert.Equal(t, "iso-8859-5", req.NegotiateCharset("utf-8", "iso-8859-5"))
+	assert.Equal(t, "", req.NegotiateCharset("utf-8"))
+}
+
+type negotiationRows [][]string
+
+func TestRespond(t *testing.T) {
+	RegisterEncoder("text/csv", func(w io.Writer, v interface{}) error {
+		rows, ok := v.(negotiationRows)
+		if !ok {
+			return fmt.Errorf("can't encode %T as CSV", v)
+		}
+		return csv.NewWriter(w).WriteAll(rows)
+	})
+
+	router := New(Context{})
+	router.Get("/rows", func(rw ResponseWriter, req *Request) {
+		Respond(rw, req, negotiationRows{{"a", "b"}, {"1", "2"}})
+	})
+
+	for _, tc := range []struct {
+		accept      string
+		contentType string
+		body        string
+		code        int
+	}{
+		{"text/csv", "text/csv; charset=utf-8", "a,b\n1,2", 200},
+		{"application/json", "application/json; charset=utf-8", `[["a","b"],["1","2"]]`, 200},
+		{"*/*", "application/json; charset=utf-8", `[["a","b"],["1","2"]]`, 200},
+		{"image/png", "text/plain; charset=utf-8", "Not Acceptable", http.StatusNotAcceptable},
+	} {
+		req, _ := http.NewRequest("GET", "/rows", nil)
+		req.Header.Set("Accept", tc.accept)
+		rw := httptest.NewRecorder()
+		router.ServeHTTP(rw, req)
+		assertResponse(t, rw, tc.body, tc.code)
+		assert.Equal(t, tc.contentType, rw.Header().Get("Content-Type"), tc.accept)
+		assert.Equal(t, "Accept", rw.Header().Get("Vary"))
+	}
+}
@@ -16,3 +16,26 @@ func (r *Router) genericOptionsHandler(ctx reflect.Value, methods []string) func
 		}
 	}
 }
+
+// allowedMethods returns the methods (other than OPTIONS) that have a route matching path. It also returns the
+// last matching leaf, and the wildcards captured by the route for optionsMethod, if there is one.
+func (r *Router) allowedMethods(path string, optionsMethod string) ([]string, *pathLeaf, map[string]string) {
+	methods := make([]string, 0, len(httpMethods))
+	var lastLeaf *pathLeaf
+	var wildcardMap map[string]string
+	for _, method := range httpMethods {
+		if method == httpMethodOptions {
+			continue
+		}
+		tree := r.root[method]
+		leaf, wildcards := tree.Match(path)
+		if leaf != nil {
+			methods = append(methods, string(method))
+			lastLeaf = leaf
+			if optionsMethod == string(method) {
+				wildcardMap = wildcards
+			}
+		}
+	}
+	return methods, lastLeaf, wildcardMap
+}
@@ -3,6 +3,7 @@ package web
 import (
 	"log"
 	"os"
+	"time"
 )
 
 // PanicReporter can receive panics that happen when serving a request and report them to a log of some sort.
@@ -11,6 +12,15 @@ type PanicReporter interface {
 	Panic(url string, err interface{}, stack string)
 }
 
+// RequestPanicReporter is a PanicReporter that wants the request that panicked, eg to report its RequestID.
+// If PanicHandler implements it, RequestPanic is called instead of Panic.
+type RequestPanicReporter interface {
+	PanicReporter
+
+	// RequestPanic is called with the request, the result of calling recover, and the stack.
+	RequestPanic(req *Request, err interface{}, stack string)
+}
+
 // PanicHandler will be logged to in panic conditions (eg, division by zero in an app handler).
 // Applications can set web.PanicHandler = your own logger, if they wish.
 // In terms of logging the requests / responses, see logger_middleware. That is a completely separate system.
@@ -25,3 +35,19 @@ type logPanicReporter struct {
 func (l logPanicReporter) Panic(url string, err interface{}, stack string) {
 	l.log.Printf("PANIC\nURL: %v\nERROR: %v\nSTACK:\n%s\n", url, err, stack)
 }
+
+func (l logPanicReporter) RequestPanic(req *Request, err interface{}, stack string) {
+	if req.RequestID() == "" {
+		l.Panic(req.URL.String(), err, stack)
+		return
+	}
+	l.log.Printf("PANIC\nURL: %v\nREQUEST ID: %s\nERROR: %v\nSTACK:\n%s\n", req.URL, req.RequestID(), err, stack)
+}
+
+func (l logPanicReporter) Timeout(req *Request, timeout time.Duration) {
+	if req.RequestID() == "" {
+		l.log.Printf("TIMEOUT\nURL: %v\nROUTE: %s\nTIMEOUT: %v\n", req.URL, req.RoutePath(), timeout)
+		return
+	}
+	l.log.Printf("TIMEOUT\nURL: %v\nREQUEST ID: %s\nROUTE: %s\nTIMEOUT: %v\n", req.URL, req.RequestID(), req.RoutePath(), timeout)
+}
@@ -0,0 +1,276 @@
+package web
+
+import (
+	"hash/fnv"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimit allows Requests requests per Period, eg RateLimit{Requests: 100, Period: time.Minute}. Both must be
+// positive; RateLimitMiddleware panics otherwise.
+type RateLimit struct {
+	Requests int
+	Period   time.Duration
+}
+
+// RateLimitResult is a RateLimitStore's decision about a request.
+type RateLimitResult struct {
+	Allowed    bool
+	Remaining  int           // Requests left before the limit is hit
+	Reset      time.Duration // Until the full limit is available again
+	RetryAfter time.Duration // Until a request will be allowed again, if this one wasn't
+}
+
+// RateLimitStore keeps the state of rate limits. Take counts a request against the limit for key, and says whether
+// it's allowed. Implement it to share limits between servers, eg in Redis. It must be safe to call from several
+// goroutines.
+type RateLimitStore interface {
+	Take(key string, limit RateLimit) (RateLimitResult, error)
+}
+
+// RateLimitKeyFunc returns the key that a request is counted under, eg the client's IP. Requests with an empty key
+// aren't limited.
+type RateLimitKeyFunc func(req *Request) string
+
+// RateLimitByIP keys requests by the client's IP, from RemoteAddr. Behind a proxy, make sure RemoteAddr is set
+// from the proxy's headers first.
+func RateLimitByIP(req *Request) string {
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		return host
+	}
+	return req.RemoteAddr
+}
+
+// RateLimitByHeader returns a RateLimitKeyFunc that keys requests by a header, eg an API key. Requests without the
+// header are keyed by the client's IP, as with RateLimitByIP, so that leaving it out doesn't get around the limit.
+func RateLimitByHeader(name string) RateLimitKeyFunc {
+	return func(req *Request) string {
+		if value := req.Header.Get(name); value != "" {
+			return value
+		}
+		ip := RateLimitByIP(req)
+		if ip == "" {
+			return ""
+		}
+		// Header values can't contain a NUL, so an IP can't be passed off as a header value or vice versa.
+		return "\x00" + ip
+	}
+}
+
+// RateLimitByRoute keys requests by their method and route (eg, "GET /users/:id"), so that each route has its own
+// limit shared by every client.
+func RateLimitByRoute(req *Request) string {
+	return req.Method + " " + req.upcomingRoutePath()
+}
+
+// RateLimitOption configures RateLimitMiddleware.
+type RateLimitOption struct {
+	// If set, Key returns the key that requests are counted under. The default is RateLimitByIP.
+	Key RateLimitKeyFunc
+
+	// If set, Store keeps the counts. The default is a new NewTokenBucketStore.
+	Store RateLimitStore
+
+	// If set, Routes overrides the limit for some routes, keyed by method and route, eg "POST /login". Each route
+	// has its own counts.
+	Routes map[string]RateLimit
+}
+
+// RateLimitMiddleware returns a middleware that limits requests to limit, per key. Every response gets
+// RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset and RateLimit-Policy headers, and requests over the limit
+// get a 429 Too Many Requests with Retry-After. Different limits can also be set for subrouters, by adding
+// another RateLimitMiddleware to them. If the store fails, requests are allowed.
+func RateLimitMiddleware(limit RateLimit, options ...RateLimitOption) func(ResponseWriter, *Request, NextMiddlewareFunc) {
+	var option RateLimitOption
+	if len(options) > 0 {
+		option = options[0]
+	}
+	if option.Key == nil {
+		option.Key = RateLimitByIP
+	}
+	if option.Store == nil {
+		option.Store = NewTokenBucketStore()
+	}
+	limit.mustBeValid("")
+	for route, routeLimit := range option.Routes {
+		routeLimit.mustBeValid(" for " + route)
+	}
+
+	return func(rw ResponseWriter, req *Request, next NextMiddlewareFunc) {
+		key := option.Key(req)
+		if key == "" {
+			next(rw, req)
+			return
+		}
+
+		limit := limit
+		if len(option.Routes) > 0 {
+			route := req.Method + " " + req.upcomingRoutePath()
+			if routeLimit, ok := option.Routes[route]; ok {
+				limit = routeLimit
+				key = route + "\x00" + key
+			}
+		}
+
+		result, err := option.Store.Take(key, limit)
+		if err != nil {
+			next(rw, req)
+			return
+		}
+
+		header := rw.Header()
+		header.Set("RateLimit-Limit", strconv.Itoa(limit.Requests))
+		header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		header.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+		header.Set("RateLimit-Policy", strconv.Itoa(limit.Requests)+";w="+strconv.Itoa(ceilSeconds(limit.Period)))
+		if !result.Allowed {
+			header.Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+			http.Error(rw, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+		next(rw, req)
+	}
+}
+
+func (l RateLimit) mustBeValid(what string) {
+	if l.Requests <= 0 || l.Period <= 0 {
+		panic("web: invalid RateLimit" + what + ": Requests and Period must be positive")
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// NewTokenBucketStore returns an in-memory RateLimitStore that gives each key a bucket of limit.Requests tokens,
+// refilled evenly over limit.Period. Bursts of up to limit.Requests are allowed after a quiet spell.
+func NewTokenBucketStore() RateLimitStore {
+	return &memoryRateLimitStore{take: takeTokenBucket}
+}
+
+// NewSlidingWindowStore returns an in-memory RateLimitStore that allows limit.Requests in any window of
+// limit.Period, estimated from the counts of the current and previous fixed windows. It doesn't allow bursts at
+// window boundaries like a fixed window would.
+func NewSlidingWindowStore() RateLimitStore {
+	return &memoryRateLimitStore{take: takeSlidingWindow}
+}
+
+const rateLimitShards = 32
+
+// memoryRateLimitStore keeps its state in shards, each with its own lock, to limit contention.
+type memoryRateLimitStore struct {
+	take   func(state *rateLimitState, limit RateLimit, now time.Time) RateLimitResult
+	shards [rateLimitShards]rateLimitShard
+}
+
+type rateLimitShard struct {
+	sync.Mutex
+	states map[string]*rateLimitState
+	takes  int // since the last sweep
+}
+
+type rateLimitState struct {
+	// For token buckets, tokens is the number of tokens at time. For sliding windows, time is the start of the
+	// current window, and tokens and previous are the counts of the current and previous windows. A new state has
+	// tokens of -1.
+	tokens   float64
+	previous float64
+	time     time.Time
+	expires  time.Time // When the state is back to its initial value, and can be forgotten
+}
+
+func (s *memoryRateLimitStore) Take(key string, limit RateLimit) (RateLimitResult, error) {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	shard := &s.shards[h.Sum32()%rateLimitShards]
+	now := time.Now()
+
+	shard.Lock()
+	defer shard.Unlock()
+	if shard.states == nil {
+		shard.states = make(map[string]*rateLimitState)
+	}
+	// Forget expired states every so often, but not so often that sweeping costs more than the takes between sweeps.
+	shard.takes++
+	if shard.takes >= 64 && shard.takes >= len(shard.states) {
+		shard.takes = 0
+		for k, state := range shard.states {
+			if now.After(state.expires) {
+				delete(shard.states, k)
+			}
+		}
+	}
+
+	state := shard.states[key]
+	if state == nil {
+		state = &rateLimitState{tokens: -1}
+		shard.states[key] = state
+	}
+	return s.take(state, limit, now), nil
+}
+
+func takeTokenBucket(state *rateLimitState, limit RateLimit, now time.Time) RateLimitResult {
+	capacity := float64(limit.Requests)
+	rate := capacity / limit.Period.Seconds() // tokens per second
+	if state.tokens < 0 {
+		state.tokens = capacity
+	} else {
+		state.tokens = math.Min(capacity, state.tokens+now.Sub(state.time).Seconds()*rate)
+	}
+	state.time = now
+
+	var result RateLimitResult
+	if state.tokens >= 1 {
+		state.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration((1 - state.tokens) / rate * float64(time.Second))
+	}
+	result.Remaining = int(state.tokens)
+	result.Reset = time.Duration((capacity - state.tokens) / rate * float64(time.Second))
+	state.expires = now.Add(result.Reset)
+	return result
+}
+
+func takeSlidingWindow(state *rateLimitState, limit RateLimit, now time.Time) RateLimitResult {
+	if state.tokens < 0 {
+		state.tokens, state.time = 0, now.Truncate(limit.Period)
+	}
+	if elapsed := now.Sub(state.time); elapsed >= limit.Period {
+		windows := elapsed / limit.Period
+		if windows == 1 {
+			state.previous = state.tokens
+		} else {
+			state.previous = 0
+		}
+		state.tokens = 0
+		state.time = state.time.Add(windows * limit.Period)
+	}
+
+	elapsed := now.Sub(state.time)
+	untilNextWindow := limit.Period - elapsed
+	weight := 1 - float64(elapsed)/float64(limit.Period)
+	estimate := state.previous*weight + state.tokens
+
+	var result RateLimitResult
+	if estimate+1 <= float64(limit.Requests) {
+		state.tokens++
+		estimate++
+		result.Allowed = true
+	} else {
+		// The estimate falls as the previous window's requests age out. If that isn't enough, wait at least for the
+		// next window.
+		result.RetryAfter = untilNextWindow
+		if excess := estimate + 1 - float64(limit.Requests); state.previous > 0 && excess <= state.previous*weight {
+			result.RetryAfter = time.Duration(excess / state.previous * float64(limit.Period))
+		}
+	}
+	result.Remaining = int(math.Max(0, float64(limit.Requests)-math.Ceil(estimate)))
+	result.Reset = untilNextWindow + limit.Period
+	state.expires = state.time.Add(2 * limit.Period)
+	return result
+}
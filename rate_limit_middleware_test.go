@@ -0,0 +1,171 @@
+package web
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+type failingRateLimitStore struct{}
+
+func (failingRateLimitStore) Take(key string, limit RateLimit) (RateLimitResult, error) {
+	return RateLimitResult{}, errors.New("store is down")
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	router := New(Context{})
+	router.Middleware(RateLimitMiddleware(RateLimit{Requests: 2, Period: time.Minute}))
+	router.Get("/action", (*Context).A)
+
+	for i, remaining := range []string{"1", "0"} {
+		rw, req := newTestRequest("GET", "/action")
+		req.RemoteAddr = "10.0.0.1:1234"
+		router.ServeHTTP(rw, req)
+		assertResponse(t, rw, "context-A", 200)
+		assert.Equal(t, "2", rw.Header().Get("RateLimit-Limit"), i)
+		assert.Equal(t, remaining, rw.Header().Get("RateLimit-Remaining"), i)
+		assert.Equal(t, "2;w=60", rw.Header().Get("RateLimit-Policy"), i)
+		assert.Equal(t, "", rw.Header().Get("Retry-After"), i)
+	}
+
+	rw, req := newTestRequest("GET", "/action")
+	req.RemoteAddr = "10.0.0.1:5678"
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "Too Many Requests", 429)
+	assert.Equal(t, "0", rw.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "30", rw.Header().Get("Retry-After"))
+	assert.Equal(t, "60", rw.Header().Get("RateLimit-Reset"))
+
+	// Other clients have their own limit:
+	rw, req = newTestRequest("GET", "/action")
+	req.RemoteAddr = "10.0.0.2:1234"
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "context-A", 200)
+}
+
+func TestRateLimitKeysAndRoutes(t *testing.T) {
+	router := New(Context{})
+	router.Middleware(RateLimitMiddleware(RateLimit{Requests: 1, Period: time.Hour}, RateLimitOption{
+		Key:    RateLimitByHeader("X-API-Key"),
+		Routes: map[string]RateLimit{"GET /users/:id": {Requests: 2, Period: time.Hour}},
+	}))
+	router.Get("/action", (*Context).A)
+	router.Get("/users/:id", (*Context).A)
+
+	serve := func(path, apiKey string) int {
+		rw, req := newTestRequest("GET", path)
+		req.RemoteAddr = "10.0.0.1:1234"
+		if apiKey != "" {
+			req.Header.Set("X-API-Key", apiKey)
+		}
+		router.ServeHTTP(rw, req)
+		return rw.Code
+	}
+	assert.Equal(t, 200, serve("/action", "a"))
+	assert.Equal(t, 429, serve("/action", "a"))
+	assert.Equal(t, 200, serve("/action", "b"))
+	// Requests without a key are limited by IP, which can't be passed off as a key:
+	assert.Equal(t, 200, serve("/action", ""))
+	assert.Equal(t, 429, serve("/action", ""))
+	assert.Equal(t, 200, serve("/action", "10.0.0.1"))
+
+	// The route has its own limit and counts:
+	assert.Equal(t, 200, serve("/users/1", "a"))
+	assert.Equal(t, 200, serve("/users/2", "a"))
+	assert.Equal(t, 429, serve("/users/3", "a"))
+
+	// Keyed by route, in a subrouter:
+	router = New(Context{})
+	admin := router.Subrouter(AdminContext{}, "/admin")
+	admin.Middleware(RateLimitMiddleware(RateLimit{Requests: 1, Period: time.Hour}, RateLimitOption{Key: RateLimitByRoute}))
+	admin.Get("/users/:id", (*AdminContext).B)
+	admin.Get("/action", (*AdminContext).B)
+	assert.Equal(t, 200, serve("/admin/users/1", "a"))
+	assert.Equal(t, 429, serve("/admin/users/2", "b"))
+	assert.Equal(t, 200, serve("/admin/action", "a"))
+
+	// A failing store lets requests through:
+	router = New(Context{})
+	router.Middleware(RateLimitMiddleware(RateLimit{Requests: 1, Period: time.Hour}, RateLimitOption{Store: failingRateLimitStore{}}))
+	router.Get("/action", (*Context).A)
+	assert.Equal(t, 200, serve("/action", ""))
+	assert.Equal(t, 200, serve("/action", ""))
+}
+
+func TestRateLimitValidation(t *testing.T) {
+	assert.Panics(t, func() { RateLimitMiddleware(RateLimit{Requests: 10}) })
+	assert.Panics(t, func() { RateLimitMiddleware(RateLimit{Period: time.Second}) })
+	assert.Panics(t, func() {
+		RateLimitMiddleware(RateLimit{Requests: 10, Period: time.Second}, RateLimitOption{
+			Routes: map[string]RateLimit{"POST /login": {Requests: -1, Period: time.Second}},
+		})
+	})
+}
+
+func TestTokenBucket(t *testing.T) {
+	limit := RateLimit{Requests: 10, Period: 10 * time.Second}
+	state := &rateLimitState{tokens: -1}
+	now := time.Now()
+
+	for i := 0; i < 10; i++ {
+		result := takeTokenBucket(state, limit, now)
+		assert.True(t, result.Allowed)
+		assert.Equal(t, 9-i, result.Remaining)
+	}
+	result := takeTokenBucket(state, limit, now)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, time.Second, result.RetryAfter)
+	assert.Equal(t, 10*time.Second, result.Reset)
+
+	// Tokens refill evenly:
+	result = takeTokenBucket(state, limit, now.Add(2500*time.Millisecond))
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 1, result.Remaining)
+	result = takeTokenBucket(state, limit, now.Add(time.Hour))
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 9, result.Remaining)
+}
+
+func TestSlidingWindow(t *testing.T) {
+	limit := RateLimit{Requests: 10, Period: time.Minute}
+	state := &rateLimitState{tokens: -1}
+	start := time.Now().Truncate(time.Minute)
+
+	for i := 0; i < 10; i++ {
+		assert.True(t, takeSlidingWindow(state, limit, start.Add(50*time.Second)).Allowed)
+	}
+	result := takeSlidingWindow(state, limit, start.Add(50*time.Second))
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+	assert.Equal(t, 10*time.Second, result.RetryAfter)
+
+	// Unlike a fixed window, the next window doesn't start with the full limit. A quarter into it, the previous
+	// window still counts for 7.5 requests:
+	result = takeSlidingWindow(state, limit, start.Add(75*time.Second))
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 1, result.Remaining)
+	assert.True(t, takeSlidingWindow(state, limit, start.Add(75*time.Second)).Allowed)
+	result = takeSlidingWindow(state, limit, start.Add(75*time.Second))
+	assert.False(t, result.Allowed)
+	// 0.5 excess requests age out in 3 seconds:
+	assert.Equal(t, 3*time.Second, result.RetryAfter)
+
+	// After two quiet windows, everything's forgotten:
+	result = takeSlidingWindow(state, limit, start.Add(5*time.Minute))
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 9, result.Remaining)
+}
+
+func TestRateLimitStoreSweep(t *testing.T) {
+	store := NewTokenBucketStore().(*memoryRateLimitStore)
+	for i := 0; i < 10000; i++ {
+		_, err := store.Take(string(rune('a'+i%26))+time.Duration(i).String(), RateLimit{Requests: 1, Period: time.Nanosecond})
+		assert.NoError(t, err)
+	}
+	total := 0
+	for i := range store.shards {
+		total += len(store.shards[i].states)
+	}
+	assert.True(t, total < 5000, "%d states", total)
+}
@@ -0,0 +1,123 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// JSONOptions configures how JSON encodes values. See encoding/json's Encoder.SetIndent and SetEscapeHTML.
+type JSONOptions struct {
+	Prefix     string
+	Indent     string
+	EscapeHTML bool
+}
+
+// DefaultJSONOptions is used by JSON. Applications can set it to indent output or to stop escaping HTML, if they wish.
+var DefaultJSONOptions = JSONOptions{EscapeHTML: true}
+
+// RenderError is what the render helpers panic with when a value can't be encoded. Since nothing has been
+// written at that point, your Error handler is free to render its own response.
+type RenderError struct {
+	Err error
+}
+
+func (e *RenderError) Error() string {
+	return "web: couldn't render response: " + e.Err.Error()
+}
+
+// JSON renders v as JSON with the given status code, encoded according to DefaultJSONOptions.
+func JSON(rw ResponseWriter, status int, v interface{}) {
+	var buf bytes.Buffer
+	if err := encodeJSON(&buf, v); err != nil {
+		panic(&RenderError{Err: err})
+	}
+	writeRendered(rw, status, "application/json; charset=utf-8", buf.Bytes())
+}
+
+// XML renders v as XML, preceded by the standard XML header, with the given status code.
+func XML(rw ResponseWriter, status int, v interface{}) {
+	var buf bytes.Buffer
+	if err := encodeXML(&buf, v); err != nil {
+		panic(&RenderError{Err: err})
+	}
+	writeRendered(rw, status, "application/xml; charset=utf-8", buf.Bytes())
+}
+
+// Text renders s as plain text with the given status code.
+func Text(rw ResponseWriter, status int, s string) {
+	writeRendered(rw, status, "text/plain; charset=utf-8", []byte(s))
+}
+
+// HTML executes the template named name in tpl with data, and renders the result with the given status code.
+// If name is "", tpl itself is executed. The template is executed before anything is written, so an error
+// can still be rendered by your Error handler.
+func HTML(rw ResponseWriter, status int, tpl *template.Template, name string, data interface{}) {
+	var buf bytes.Buffer
+	var err error
+	if name == "" {
+		err = tpl.Execute(&buf, data)
+	} else {
+		err = tpl.ExecuteTemplate(&buf, name, data)
+	}
+	if err != nil {
+		panic(&RenderError{Err: err})
+	}
+	writeRendered(rw, status, "text/html; charset=utf-8", buf.Bytes())
+}
+
+// NoContent writes a 204 No Content status and no body.
+func NoContent(rw ResponseWriter) {
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// Redirect redirects the request to url (which may be relative to the request path) with the given 3xx status code.
+func Redirect(rw ResponseWriter, req *Request, url string, status int) {
+	http.Redirect(rw, req.Request, url, status)
+}
+
+// writeRendered writes body with Content-Type (unless the handler already set one) and Content-Length.
+// For HEAD requests the headers are written but the body isn't.
+func writeRendered(rw ResponseWriter, status int, contentType string, body []byte) {
+	header := rw.Header()
+	if header.Get("Content-Type") == "" {
+		header.Set("Content-Type", contentType)
+	}
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	rw.WriteHeader(status)
+	if !isHeadResponse(rw) {
+		rw.Write(body)
+	}
+}
+
+// isHeadResponse returns whether rw is the response to a HEAD request.
+func isHeadResponse(rw ResponseWriter) bool {
+	if w := unwrapAppResponseWriter(rw); w != nil {
+		return w.head
+	}
+	return false
+}
+
+func encodeJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent(DefaultJSONOptions.Prefix, DefaultJSONOptions.Indent)
+	enc.SetEscapeHTML(DefaultJSONOptions.EscapeHTML)
+	return enc.Encode(v)
+}
+
+func encodeXML(w io.Writer, v interface{}) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	return xml.NewEncoder(w).Encode(v)
+}
+
+func encodeText(w io.Writer, v interface{}) error {
+	_, err := fmt.Fprint(w, v)
+	return err
+}
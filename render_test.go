@@ -0,0 +1,111 @@
+package web
+
+import (
+	"github.com/stretchr/testify/assert"
+	"html/template"
+	"net/http"
+	"testing"
+)
+
+type renderTicket struct {
+	ID    int    `json:"id" xml:"id,attr"`
+	Title string `json:"title" xml:"title"`
+}
+
+func TestRenderJSON(t *testing.T) {
+	router := New(Context{})
+	router.Get("/ticket", func(rw ResponseWriter, req *Request) {
+		JSON(rw, http.StatusCreated, renderTicket{ID: 1, Title: "<b>hi</b>"})
+	})
+
+	rw, req := newTestRequest("GET", "/ticket")
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, `{"id":1,"title":"\u003cb\u003ehi\u003c/b\u003e"}`, http.StatusCreated)
+	assert.Equal(t, "application/json; charset=utf-8", rw.Header().Get("Content-Type"))
+	assert.Equal(t, "49", rw.Header().Get("Content-Length"))
+
+	// HEAD requests get the headers but no body:
+	rw, req = newTestRequest("HEAD", "/ticket")
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "", http.StatusCreated)
+	assert.Equal(t, "49", rw.Header().Get("Content-Length"))
+}
+
+func TestRenderJSONOptions(t *testing.T) {
+	defer func(opts JSONOptions) { DefaultJSONOptions = opts }(DefaultJSONOptions)
+	DefaultJSONOptions = JSONOptions{Indent: "  "}
+
+	router := New(Context{})
+	router.Get("/ticket", func(rw ResponseWriter, req *Request) {
+		JSON(rw, http.StatusOK, renderTicket{ID: 1, Title: "<b>"})
+	})
+
+	rw, req := newTestRequest("GET", "/ticket")
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "{\n  \"id\": 1,\n  \"title\": \"<b>\"\n}", http.StatusOK)
+}
+
+func TestRenderJSONError(t *testing.T) {
+	router := New(Context{})
+	router.Error(func(rw ResponseWriter, req *Request, err interface{}) {
+		_, ok := err.(*RenderError)
+		assert.True(t, ok)
+		Text(rw, http.StatusInternalServerError, "render failed")
+	})
+	router.Get("/bad", func(rw ResponseWriter, req *Request) {
+		JSON(rw, http.StatusOK, map[string]interface{}{"ch": make(chan int)})
+	})
+
+	rw, req := newTestRequest("GET", "/bad")
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "render failed", http.StatusInternalServerError)
+	assert.Equal(t, "text/plain; charset=utf-8", rw.Header().Get("Content-Type"))
+}
+
+func TestRenderXMLTextHTML(t *testing.T) {
+	tpl := template.Must(template.New("page").Parse(`<p>{{.}}</p>`))
+
+	router := New(Context{})
+	router.Get("/xml", func(rw ResponseWriter, req *Request) {
+		XML(rw, http.StatusOK, renderTicket{ID: 2, Title: "x"})
+	})
+	router.Get("/text", func(rw ResponseWriter, req *Request) {
+		Text(rw, http.StatusAccepted, "queued")
+	})
+	router.Get("/html", func(rw ResponseWriter, req *Request) {
+		HTML(rw, http.StatusOK, tpl, "", "<script>")
+	})
+
+	rw, req := newTestRequest("GET", "/xml")
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, `<?xml version="1.0" encoding="UTF-8"?>`+"\n"+`<renderTicket id="2"><title>x</title></renderTicket>`, http.StatusOK)
+	assert.Equal(t, "application/xml; charset=utf-8", rw.Header().Get("Content-Type"))
+
+	rw, req = newTestRequest("GET", "/text")
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "queued", http.StatusAccepted)
+
+	rw, req = newTestRequest("GET", "/html")
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "<p>&lt;script&gt;</p>", http.StatusOK)
+	assert.Equal(t, "text/html; charset=utf-8", rw.Header().Get("Content-Type"))
+}
+
+func TestRenderNoContentAndRedirect(t *testing.T) {
+	router := New(Context{})
+	router.Delete("/ticket", func(rw ResponseWriter, req *Request) {
+		NoContent(rw)
+	})
+	router.Get("/old", func(rw ResponseWriter, req *Request) {
+		Redirect(rw, req, "/new", http.StatusMovedPermanently)
+	})
+
+	rw, req := newTestRequest("DELETE", "/ticket")
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "", http.StatusNoContent)
+
+	rw, req = newTestRequest("GET", "/old")
+	router.ServeHTTP(rw, req)
+	assert.Equal(t, http.StatusMovedPermanently, rw.Code)
+	assert.Equal(t, "/new", rw.Header().Get("Location"))
+}
@@ -17,8 +17,22 @@ type Request struct {
 	// The actual route that got invoked
 	route *route
 
+	rootRouter    *Router       // The router serving the request. Set immediately.
 	rootContext   reflect.Value // Root context. Set immediately.
 	targetContext reflect.Value // The target context corresponding to the route. Not set until root middleware is done.
+
+	requestID string // Set by RequestIDMiddleware.
+	traceSpan *Span  // The span to nest middleware and handler spans in. Set by TracingMiddleware if MiddlewareSpans.
+
+	upcoming upcomingRoute // The route calculated before routing, by upcomingRoute.
+
+	panicHandled []func(err interface{}) // Called once a panic has been answered. See afterPanic.
+}
+
+type upcomingRoute struct {
+	method, path string // What the route was calculated for. An empty method means it wasn't.
+	route        *route
+	params       map[string]string
 }
 
 // IsRouted can be called from middleware to determine if the request has been routed yet.
@@ -26,6 +40,12 @@ func (r *Request) IsRouted() bool {
 	return r.route != nil
 }
 
+// RequestID returns the request's ID, as accepted or generated by RequestIDMiddleware. It's empty if that
+// middleware hasn't run.
+func (r *Request) RequestID() string {
+	return r.requestID
+}
+
 // RoutePath returns the routed path string. Eg, if a route was registered with
 // router.Get("/suggestions/:suggestion_id/comments", f), then RoutePath will return "/suggestions/:suggestion_id/comments".
 func (r *Request) RoutePath() string {
@@ -34,3 +54,48 @@ func (r *Request) RoutePath() string {
 	}
 	return ""
 }
+
+// upcomingRoutePath is like RoutePath, but also works in root middleware, before the request is routed.
+func (r *Request) upcomingRoutePath() string {
+	if r.IsRouted() || r.rootRouter == nil {
+		return r.RoutePath()
+	}
+	if route, _ := r.upcomingRoute(); route != nil {
+		return route.Path
+	}
+	return ""
+}
+
+// upcomingRoute calculates the route the request will take, and its path params. The result is kept, so that when
+// root middleware needs the route, routing doesn't have to calculate it again, unless the middleware changed the
+// method or path in between.
+func (r *Request) upcomingRoute() (*route, map[string]string) {
+	if r.upcoming.method == "" || r.upcoming.method != r.Method || r.upcoming.path != r.URL.Path {
+		route, params := calculateRoute(r.rootRouter, r)
+		r.upcoming = upcomingRoute{method: r.Method, path: r.URL.Path, route: route, params: params}
+	}
+	return r.upcoming.route, r.upcoming.params
+}
+
+// afterPanic arranges for f to be called with the recovered value once the panic that's unwinding through the calling
+// middleware has been answered (see PanicRecovered), so that the middleware can see the error response. If there's
+// no router to answer it, f is called right away, with nil.
+func (r *Request) afterPanic(f func(err interface{})) {
+	if r.rootRouter == nil {
+		f(nil)
+		return
+	}
+	r.panicHandled = append(r.panicHandled, f)
+}
+
+// PanicRecovered tells the middleware a panic unwound through (eg, AccessLogMiddleware) that it has been answered,
+// so that they can record the response. Middleware that recovers panics and answers them itself, as
+// ShowErrorsMiddleware does, should call it with the recovered value once the response is written. If it doesn't,
+// the router calls it with nil once the request is done.
+func (r *Request) PanicRecovered(err interface{}) {
+	handled := r.panicHandled
+	r.panicHandled = nil
+	for _, f := range handled {
+		f(err)
+	}
+}
@@ -0,0 +1,67 @@
+package web
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// RequestIDOption configures RequestIDMiddleware.
+type RequestIDOption struct {
+	// If set, Header is the request and response header that carries the ID. The default is "X-Request-ID".
+	Header string
+
+	// If set, Generate is called to make IDs for requests that don't bring a valid one. The default generates
+	// 32 random hex digits.
+	Generate func() string
+
+	// If set, incoming IDs are ignored and every request gets a new one, eg when clients aren't trusted.
+	IgnoreIncoming bool
+}
+
+// RequestIDMiddleware returns a middleware that gives every request an ID. An ID sent by the client (or a proxy
+// in front of the app) is kept if it's at most 128 printable ASCII characters; otherwise a new one is generated.
+// The ID is available from req.RequestID(), is echoed in the response, and is included in LoggerMiddleware's log
+// lines, ShowErrorsMiddleware's page and the reports of a PanicHandler that implements RequestPanicReporter.
+func RequestIDMiddleware(options ...RequestIDOption) func(ResponseWriter, *Request, NextMiddlewareFunc) {
+	var option RequestIDOption
+	if len(options) > 0 {
+		option = options[0]
+	}
+	if option.Header == "" {
+		option.Header = "X-Request-ID"
+	}
+	if option.Generate == nil {
+		option.Generate = generateRequestID
+	}
+
+	return func(rw ResponseWriter, req *Request, next NextMiddlewareFunc) {
+		id := req.Header.Get(option.Header)
+		if option.IgnoreIncoming || !validRequestID(id) {
+			id = option.Generate()
+		}
+		req.requestID = id
+		rw.Header().Set(option.Header, id)
+		next(rw, req)
+	}
+}
+
+func generateRequestID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// validRequestID reports whether id is safe to echo and log.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
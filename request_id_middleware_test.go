@@ -0,0 +1,110 @@
+package web
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"log"
+	"strings"
+	"testing"
+)
+
+func (c *Context) RequestIDAction(w ResponseWriter, r *Request) {
+	w.Write([]byte(r.RequestID()))
+}
+
+func TestRequestIDMiddleware(t *testing.T) {
+	router := New(Context{})
+	router.Middleware(RequestIDMiddleware())
+	router.Get("/action", (*Context).RequestIDAction)
+
+	// An incoming ID is kept:
+	rw, req := newTestRequest("GET", "/action")
+	req.Header.Set("X-Request-ID", "abc-123")
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "abc-123", 200)
+	assert.Equal(t, "abc-123", rw.Header().Get("X-Request-ID"))
+
+	// Otherwise one is generated:
+	rw, req = newTestRequest("GET", "/action")
+	router.ServeHTTP(rw, req)
+	assert.Regexp(t, "^[0-9a-f]{32}$", rw.Body.String())
+	assert.Equal(t, rw.Body.String(), rw.Header().Get("X-Request-ID"))
+
+	rw2, req := newTestRequest("GET", "/action")
+	router.ServeHTTP(rw2, req)
+	assert.NotEqual(t, rw.Body.String(), rw2.Body.String())
+
+	// Invalid IDs are replaced:
+	for _, id := range []string{"has space", "new\nline", strings.Repeat("x", 129)} {
+		rw, req = newTestRequest("GET", "/action")
+		req.Header.Set("X-Request-ID", id)
+		router.ServeHTTP(rw, req)
+		assert.Regexp(t, "^[0-9a-f]{32}$", rw.Body.String())
+	}
+
+	// Without the middleware, there's no ID:
+	router = New(Context{})
+	router.Get("/action", (*Context).RequestIDAction)
+	rw, req = newTestRequest("GET", "/action")
+	req.Header.Set("X-Request-ID", "abc-123")
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "", 200)
+}
+
+func TestRequestIDOption(t *testing.T) {
+	router := New(Context{})
+	router.Middleware(RequestIDMiddleware(RequestIDOption{
+		Header:         "X-Trace",
+		Generate:       func() string { return "generated" },
+		IgnoreIncoming: true,
+	}))
+	router.Get("/action", (*Context).RequestIDAction)
+
+	rw, req := newTestRequest("GET", "/action")
+	req.Header.Set("X-Trace", "abc-123")
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "generated", 200)
+	assert.Equal(t, "generated", rw.Header().Get("X-Trace"))
+	assert.Equal(t, "", rw.Header().Get("X-Request-ID"))
+}
+
+func TestRequestIDReporting(t *testing.T) {
+	var buf bytes.Buffer
+	oldLogger, oldHandler := Logger, PanicHandler
+	Logger = log.New(&buf, "", 0)
+	PanicHandler = logPanicReporter{log: log.New(&buf, "", 0)}
+	defer func() {
+		Logger, PanicHandler = oldLogger, oldHandler
+	}()
+
+	router := New(Context{})
+	router.Middleware(RequestIDMiddleware())
+	router.Middleware(LoggerMiddleware)
+	router.Get("/action", (*Context).A)
+	router.Get("/boom", (*Context).ErrorAction)
+
+	rw, req := newTestRequest("GET", "/action")
+	req.Header.Set("X-Request-ID", "abc-123")
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "context-A", 200)
+	assert.Regexp(t, `\[\d+ .{2}\] 200 '/action' abc-123`, buf.String())
+
+	buf.Reset()
+	rw, req = newTestRequest("GET", "/boom")
+	req.Header.Set("X-Request-ID", "abc-123")
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "Application Error", 500)
+	assert.Contains(t, buf.String(), "PANIC\nURL: /boom\nREQUEST ID: abc-123\n")
+
+	// ShowErrorsMiddleware shows it too:
+	router = New(Context{})
+	router.Middleware(RequestIDMiddleware())
+	router.Middleware(ShowErrorsMiddleware)
+	router.Get("/boom", (*Context).ErrorAction)
+
+	rw, req = newTestRequest("GET", "/boom")
+	req.Header.Set("X-Request-ID", "abc-123")
+	router.ServeHTTP(rw, req)
+	assert.Equal(t, 500, rw.Code)
+	assert.Contains(t, rw.Body.String(), "<strong>Request ID:</strong> abc-123")
+}
@@ -3,12 +3,14 @@ package web
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 )
 
 // ResponseWriter includes net/http's ResponseWriter and adds a StatusCode() method to obtain the written status code.
-// A ResponseWriter is sent to handlers on each request.
+// A ResponseWriter is sent to handlers on each request. It's also an http.Pusher when the underlying ResponseWriter
+// supports HTTP/2 server push, and it works with http.NewResponseController.
 type ResponseWriter interface {
 	http.ResponseWriter
 	http.Flusher
@@ -27,6 +29,36 @@ type appResponseWriter struct {
 	http.ResponseWriter
 	statusCode int
 	size       int
+	head       bool // Whether this is the response to a HEAD request. The render helpers skip the body if so.
+}
+
+// pushingResponseWriter is the ResponseWriter handlers get when the underlying ResponseWriter supports
+// HTTP/2 server push, so that checking for http.Pusher tells handlers whether pushing is possible.
+type pushingResponseWriter struct {
+	*appResponseWriter
+}
+
+func (w *pushingResponseWriter) Push(target string, opts *http.PushOptions) error {
+	return w.ResponseWriter.(http.Pusher).Push(target, opts)
+}
+
+// readerFromResponseWriter is the ResponseWriter handlers get when the underlying ResponseWriter is an io.ReaderFrom,
+// so that io.Copy (and so http.ServeContent) can use its sendfile fast path.
+type readerFromResponseWriter struct {
+	*appResponseWriter
+}
+
+func (w *readerFromResponseWriter) ReadFrom(r io.Reader) (int64, error) {
+	return w.readFrom(r)
+}
+
+// pushingReaderFromResponseWriter is the ResponseWriter handlers get when the underlying ResponseWriter is both.
+type pushingReaderFromResponseWriter struct {
+	pushingResponseWriter
+}
+
+func (w *pushingReaderFromResponseWriter) ReadFrom(r io.Reader) (int64, error) {
+	return w.readFrom(r)
 }
 
 // Don't need this yet because we get it for free:
@@ -74,3 +106,38 @@ func (w *appResponseWriter) Flush() {
 		flusher.Flush()
 	}
 }
+
+// Unwrap returns the underlying ResponseWriter, so http.NewResponseController can reach its methods.
+func (w *appResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// readFrom is ReadFrom for the ResponseWriters whose underlying ResponseWriter is an io.ReaderFrom.
+func (w *appResponseWriter) readFrom(r io.Reader) (n int64, err error) {
+	if w.statusCode == 0 {
+		w.statusCode = http.StatusOK
+	}
+	n, err = w.ResponseWriter.(io.ReaderFrom).ReadFrom(r)
+	w.size += int(n)
+	return n, err
+}
+
+// unwrapAppResponseWriter returns the appResponseWriter that rw is or wraps, or nil if there isn't one.
+func unwrapAppResponseWriter(rw http.ResponseWriter) *appResponseWriter {
+	for {
+		switch w := rw.(type) {
+		case *appResponseWriter:
+			return w
+		case *pushingResponseWriter:
+			return w.appResponseWriter
+		case *readerFromResponseWriter:
+			return w.appResponseWriter
+		case *pushingReaderFromResponseWriter:
+			return w.appResponseWriter
+		case interface{ Unwrap() http.ResponseWriter }:
+			rw = w.Unwrap()
+		default:
+			return nil
+		}
+	}
+}
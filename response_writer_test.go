@@ -3,9 +3,11 @@ package web
 import (
 	"bufio"
 	"github.com/stretchr/testify/assert"
+	"io"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 )
@@ -111,3 +113,105 @@ func TestResponseWriterCloseNotify(t *testing.T) {
 	}
 	assert.True(t, closed)
 }
+
+type pushableRecorder struct {
+	*httptest.ResponseRecorder
+	pushed []string
+}
+
+func (p *pushableRecorder) Push(target string, opts *http.PushOptions) error {
+	p.pushed = append(p.pushed, target)
+	return nil
+}
+
+type readerFromRecorder struct {
+	*httptest.ResponseRecorder
+	readFrom bool
+}
+
+func (r *readerFromRecorder) ReadFrom(src io.Reader) (int64, error) {
+	r.readFrom = true
+	return io.Copy(r.ResponseRecorder, src)
+}
+
+func TestResponseWriterPush(t *testing.T) {
+	var pushed, isPusher bool
+	router := New(Context{})
+	router.Get("/", func(rw ResponseWriter, req *Request) {
+		var pusher http.Pusher
+		pusher, isPusher = rw.(http.Pusher)
+		if isPusher {
+			pushed = pusher.Push("/app.css", nil) == nil
+		}
+		rw.Write([]byte("hi"))
+	})
+
+	// A plain ResponseWriter can't push, so handlers don't see an http.Pusher:
+	rw, req := newTestRequest("GET", "/")
+	router.ServeHTTP(rw, req)
+	assert.False(t, isPusher)
+	assert.Equal(t, "hi", rw.Body.String())
+
+	rec := &pushableRecorder{ResponseRecorder: httptest.NewRecorder()}
+	router.ServeHTTP(rec, req)
+	assert.True(t, isPusher)
+	assert.True(t, pushed)
+	assert.Equal(t, []string{"/app.css"}, rec.pushed)
+	assert.Equal(t, "hi", rec.Body.String())
+}
+
+func TestResponseWriterReadFrom(t *testing.T) {
+	var isReaderFrom, isPusher bool
+	var status, size int
+	router := New(Context{})
+	router.Get("/", func(rw ResponseWriter, req *Request) {
+		_, isReaderFrom = rw.(io.ReaderFrom)
+		_, isPusher = rw.(http.Pusher)
+		io.Copy(rw, io.LimitReader(strings.NewReader("Hello world"), 100))
+		status, size = rw.StatusCode(), rw.Size()
+	})
+
+	// A plain ResponseWriter isn't an io.ReaderFrom, so handlers don't see one:
+	rw, req := newTestRequest("GET", "/")
+	router.ServeHTTP(rw, req)
+	assert.False(t, isReaderFrom)
+	assert.Equal(t, "Hello world", rw.Body.String())
+
+	rec := &readerFromRecorder{ResponseRecorder: httptest.NewRecorder()}
+	router.ServeHTTP(rec, req)
+	assert.True(t, isReaderFrom)
+	assert.False(t, isPusher)
+	assert.True(t, rec.readFrom)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, 11, size)
+	assert.Equal(t, "Hello world", rec.Body.String())
+
+	both := &struct {
+		*readerFromRecorder
+		http.Pusher
+	}{&readerFromRecorder{ResponseRecorder: httptest.NewRecorder()}, &pushableRecorder{}}
+	router.ServeHTTP(both, req)
+	assert.True(t, isReaderFrom)
+	assert.True(t, isPusher)
+	assert.True(t, both.readFrom)
+	assert.Equal(t, 11, size)
+}
+
+func TestResponseWriterUnwrap(t *testing.T) {
+	var deadlineErr error
+	router := New(Context{})
+	router.Get("/", func(rw ResponseWriter, req *Request) {
+		deadlineErr = http.NewResponseController(rw).SetWriteDeadline(time.Now().Add(time.Minute))
+	})
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+	}
+	assert.NoError(t, deadlineErr)
+
+	rec := httptest.NewRecorder()
+	assert.Equal(t, rec, (&appResponseWriter{ResponseWriter: rec}).Unwrap())
+}
@@ -2,6 +2,7 @@ package web
 
 import (
 	"fmt"
+	"io"
 	"net/http"
 	"reflect"
 	"runtime"
@@ -10,6 +11,9 @@ import (
 type middlewareClosure struct {
 	appResponseWriter
 	Request
+	pusher                 pushingResponseWriter
+	readerFrom             readerFromResponseWriter
+	pushingReaderFrom      pushingReaderFromResponseWriter
 	Routers                []*Router
 	Contexts               []reflect.Value
 	currentMiddlewareIndex int
@@ -29,23 +33,44 @@ func (rootRouter *Router) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 	var closure middlewareClosure
 	closure.Request.Request = r
 	closure.appResponseWriter.ResponseWriter = rw
+	closure.appResponseWriter.head = r.Method == "HEAD"
 	closure.Routers = make([]*Router, 1, rootRouter.maxChildrenDepth)
 	closure.Routers[0] = rootRouter
 	closure.Contexts = make([]reflect.Value, 1, rootRouter.maxChildrenDepth)
 	closure.Contexts[0] = reflect.New(rootRouter.contextType)
 	closure.currentMiddlewareLen = len(rootRouter.middleware)
 	closure.RootRouter = rootRouter
+	closure.Request.rootRouter = rootRouter
 	closure.Request.rootContext = closure.Contexts[0]
 
+	// Handlers only see an http.Pusher or an io.ReaderFrom if the underlying ResponseWriter actually is one.
+	var writer ResponseWriter = &closure.appResponseWriter
+	_, isPusher := rw.(http.Pusher)
+	_, isReaderFrom := rw.(io.ReaderFrom)
+	switch {
+	case isPusher && isReaderFrom:
+		closure.pushingReaderFrom.appResponseWriter = &closure.appResponseWriter
+		writer = &closure.pushingReaderFrom
+	case isPusher:
+		closure.pusher.appResponseWriter = &closure.appResponseWriter
+		writer = &closure.pusher
+	case isReaderFrom:
+		closure.readerFrom.appResponseWriter = &closure.appResponseWriter
+		writer = &closure.readerFrom
+	}
+
 	// Handle errors
 	defer func() {
 		if recovered := recover(); recovered != nil {
-			rootRouter.handlePanic(&closure.appResponseWriter, &closure.Request, recovered)
+			rootRouter.handlePanic(writer, &closure.Request, recovered)
+		} else {
+			// In case a middleware recovered a panic without saying so.
+			closure.Request.PanicRecovered(nil)
 		}
 	}()
 
 	next := middlewareStack(&closure)
-	next(&closure.appResponseWriter, &closure.Request)
+	next(writer, &closure.Request)
 }
 
 // This function executes the middleware stack. It does so creating/returning an anonymous function/closure.
@@ -69,31 +94,16 @@ func middlewareStack(closure *middlewareClosure) NextMiddlewareFunc {
 			middleware = closure.Routers[closure.currentRouterIndex].middleware[closure.currentMiddlewareIndex]
 		} else {
 			// We ran out of middleware on the current router
-			if closure.currentRouterIndex == 0 {
+			if closure.currentRouterIndex == 0 && req.route == nil {
 				// If we're still on the root router, it's time to actually figure out what the route is.
 				// Do so, and update the various variables.
 				// We could also 404 at this point: if so, run NotFound handlers and return.
-				theRoute, wildcardMap := calculateRoute(closure.RootRouter, req)
+				theRoute, wildcardMap := req.upcomingRoute()
 
 				if theRoute == nil && httpMethod(req.Method) == httpMethodOptions {
-					optionsMethod := req.Header.Get("Access-Control-Request-Method")
-					methods := make([]string, 0, len(httpMethods))
+					var methods []string
 					var lastLeaf *pathLeaf
-					for _, method := range httpMethods {
-						if method == httpMethodOptions {
-							continue
-						}
-						tree := closure.RootRouter.root[method]
-						leaf, wildcards := tree.Match(req.URL.Path)
-						if leaf != nil {
-							methods = append(methods, string(method))
-							lastLeaf = leaf
-							if optionsMethod == string(method) {
-								wildcardMap = wildcards
-							}
-						}
-					}
-
+					methods, lastLeaf, wildcardMap = closure.RootRouter.allowedMethods(req.URL.Path, req.Header.Get("Access-Control-Request-Method"))
 					if len(methods) > 0 {
 						handler := &actionHandler{Generic: true, GenericHandler: closure.RootRouter.genericOptionsHandler(closure.Contexts[0], methods)}
 						theRoute = &route{Method: httpMethodOptions, Path: lastLeaf.route.Path, Router: lastLeaf.route.Router, Handler: handler}
@@ -116,6 +126,12 @@ func middlewareStack(closure *middlewareClosure) NextMiddlewareFunc {
 				req.targetContext = closure.Contexts[len(closure.Contexts)-1]
 				req.route = theRoute
 				req.PathParams = wildcardMap
+
+				// The rest of the stack runs again from here, in serveWithTimeout, now that req is routed.
+				if timeout := routeTimeout(theRoute, closure.Routers); timeout > 0 {
+					closure.serveWithTimeout(rw, req, timeout)
+					return
+				}
 			}
 
 			closure.currentMiddlewareIndex = 0
@@ -133,7 +149,9 @@ func middlewareStack(closure *middlewareClosure) NextMiddlewareFunc {
 			} else {
 				// We're done! invoke the action
 				handler := req.route.Handler
-				if handler.Generic {
+				if req.traceSpan != nil {
+					traceStep(req, handler.name(), func() { handler.invoke(closure.Contexts[len(closure.Contexts)-1], rw, req) })
+				} else if handler.Generic {
 					handler.GenericHandler(rw, req)
 				} else {
 					handler.DynamicHandler.Call([]reflect.Value{closure.Contexts[len(closure.Contexts)-1], reflect.ValueOf(rw), reflect.ValueOf(req)})
@@ -145,7 +163,12 @@ func middlewareStack(closure *middlewareClosure) NextMiddlewareFunc {
 
 		// Invoke middleware.
 		if middleware != nil {
-			middleware.invoke(closure.Contexts[closure.currentRouterIndex], rw, req, closure.Next)
+			if req.traceSpan != nil {
+				ctx := closure.Contexts[closure.currentRouterIndex]
+				traceStep(req, middleware.name(), func() { middleware.invoke(ctx, rw, req, closure.Next) })
+			} else {
+				middleware.invoke(closure.Contexts[closure.currentRouterIndex], rw, req, closure.Next)
+			}
 		}
 	}
 
@@ -160,14 +183,14 @@ func (mw *middlewareHandler) invoke(ctx reflect.Value, rw ResponseWriter, req *R
 	}
 }
 
-// Strange performance characteristics: this hurts benchmark scores.
-// func (ah *actionHandler) invoke(ctx reflect.Value, rw ResponseWriter, req *Request) {
-// 	if ah.Generic {
-// 		ah.GenericHandler(rw, req)
-// 	} else {
-// 		ah.DynamicHandler.Call([]reflect.Value{ctx, reflect.ValueOf(rw), reflect.ValueOf(req)})
-// 	}
-// }
+// Strange performance characteristics: this hurts benchmark scores, so it's only used for traced requests.
+func (ah *actionHandler) invoke(ctx reflect.Value, rw ResponseWriter, req *Request) {
+	if ah.Generic {
+		ah.GenericHandler(rw, req)
+	} else {
+		ah.DynamicHandler.Call([]reflect.Value{ctx, reflect.ValueOf(rw), reflect.ValueOf(req)})
+	}
+}
 
 func calculateRoute(rootRouter *Router, req *Request) (*route, map[string]string) {
 	var leaf *pathLeaf
@@ -241,7 +264,10 @@ func contextsFor(contexts []reflect.Value, routers []*Router) []reflect.Value {
 // If there's a panic in the root middleware (so that we don't have a route/target), then invoke the root handler or default.
 // If there's a panic in other middleware, then invoke the target action's function.
 // If there's a panic in the action handler, then invoke the target action's function.
-func (rootRouter *Router) handlePanic(rw *appResponseWriter, req *Request, err interface{}) {
+func (rootRouter *Router) handlePanic(rw ResponseWriter, req *Request, err interface{}) {
+	// Middleware the panic unwound through may be waiting to see the response.
+	defer req.PanicRecovered(err)
+
 	var targetRouter *Router  // This will be set to the router we want to use the errorHandler on.
 	var context reflect.Value // this is the context of the target router
 
@@ -268,15 +294,30 @@ func (rootRouter *Router) handlePanic(rw *appResponseWriter, req *Request, err i
 
 	if targetRouter.errorHandler.IsValid() {
 		invoke(targetRouter.errorHandler, context, []reflect.Value{reflect.ValueOf(rw), reflect.ValueOf(req), reflect.ValueOf(err)})
+	} else if sc, ok := err.(StatusCoder); ok && sc.StatusCode() < http.StatusInternalServerError {
+		// Client errors (eg, a *BindError) are expected, so render them rather than reporting a panic.
+		http.Error(rw, fmt.Sprint(err), sc.StatusCode())
+		return
+	} else if terr, ok := err.(*TimeoutError); ok {
+		http.Error(rw, DefaultTimeoutResponse, terr.StatusCode())
 	} else {
 		http.Error(rw, DefaultPanicResponse, http.StatusInternalServerError)
 	}
 
+	if terr, ok := err.(*TimeoutError); ok {
+		reportTimeout(req, terr.Timeout)
+		return
+	}
+
 	const size = 4096
 	stack := make([]byte, size)
 	stack = stack[:runtime.Stack(stack, false)]
 
-	PanicHandler.Panic(fmt.Sprint(req.URL), err, string(stack))
+	if reporter, ok := PanicHandler.(RequestPanicReporter); ok {
+		reporter.RequestPanic(req, err, string(stack))
+	} else {
+		PanicHandler.Panic(fmt.Sprint(req.URL), err, string(stack))
+	}
 }
 
 func invoke(handler reflect.Value, ctx reflect.Value, values []reflect.Value) {
@@ -290,6 +331,24 @@ func invoke(handler reflect.Value, ctx reflect.Value, values []reflect.Value) {
 	}
 }
 
+// StatusCoder is implemented by errors that know which HTTP status they should be rendered with, such as *BindError.
+// If a handler panics with a StatusCoder and no Error handler is present, a status below 500 is rendered
+// with the error's message instead of DefaultPanicResponse. Error handlers can use it to pick a status, too.
+type StatusCoder interface {
+	StatusCode() int
+}
+
+// panicStatus returns the status a response to a panic with err has, if it's answered without an Error handler.
+func panicStatus(err interface{}) int {
+	if sc, ok := err.(StatusCoder); ok && sc.StatusCode() < http.StatusInternalServerError {
+		return sc.StatusCode()
+	}
+	if terr, ok := err.(*TimeoutError); ok {
+		return terr.StatusCode()
+	}
+	return http.StatusInternalServerError
+}
+
 // DefaultNotFoundResponse is the default text rendered when no route is found and no NotFound handlers are present.
 var DefaultNotFoundResponse = "Not Found"
 
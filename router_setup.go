@@ -3,6 +3,7 @@ package web
 import (
 	"reflect"
 	"strings"
+	"time"
 )
 
 type httpMethod string
@@ -48,6 +49,9 @@ type Router struct {
 
 	// This can only be set on the root handler, since by virtue of not finding a route, we don't have a target.
 	optionsHandler reflect.Value
+
+	// This can be set on any router. The deepest router with a timeout sets the route's timeout.
+	timeout time.Duration
 }
 
 // NextMiddlewareFunc are functions passed into your middleware. To advance the middleware, call the function.
@@ -69,6 +73,7 @@ type route struct {
 	Method  httpMethod
 	Path    string
 	Handler *actionHandler
+	timeout time.Duration // Overrides the routers' timeout if set. See RouteTimeout.
 }
 
 type middlewareHandler struct {
@@ -159,6 +164,29 @@ func (r *Router) Error(fn interface{}) *Router {
 	return r
 }
 
+// Timeout sets the timeout of requests routed to this router and its subrouters (unless they set their own), and
+// returns the router. The timeout covers the route's middleware (but not the root router's) and handler. When it
+// expires, the request's context is cancelled, and the router's Error handler is called with a *TimeoutError, or a
+// 503 Service Unavailable is sent. Use RouteTimeout to set the timeout of a single route.
+func (r *Router) Timeout(timeout time.Duration) *Router {
+	r.timeout = timeout
+	return r
+}
+
+// RouteTimeout sets the timeout of this router's route for method and path, as they were passed to eg Get, and
+// returns the router. It overrides the routers' timeouts, and otherwise works like Timeout. It panics if there's no
+// such route, so add the route first.
+func (r *Router) RouteTimeout(method, path string, timeout time.Duration) *Router {
+	fullPath := appendPath(r.pathPrefix, path)
+	for _, route := range r.routes {
+		if route.Method == httpMethod(method) && route.Path == fullPath {
+			route.timeout = timeout
+			return r
+		}
+	}
+	panic("web: RouteTimeout: no route for " + method + " " + fullPath)
+}
+
 // NotFound sets the specified function as the not-found handler (when no route matches) and returns the router.
 // Note that only the root router can have a NotFound handler.
 func (r *Router) NotFound(fn interface{}) *Router {
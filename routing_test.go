@@ -392,3 +392,29 @@ func TestIsRouted(t *testing.T) {
 	router.ServeHTTP(rw, req)
 	assertResponse(t, rw, "/a", 200)
 }
+
+func TestUpcomingRoutePath(t *testing.T) {
+	router := New(Context{})
+	router.Middleware(func(w ResponseWriter, r *Request, next NextMiddlewareFunc) {
+		if path := r.upcomingRoutePath(); path != "/users/:id" {
+			t.Error("Expected the upcoming route to be /users/:id but got", path)
+		}
+		// The route is calculated again if the path changes:
+		if r.URL.Query().Get("rewrite") != "" {
+			r.URL.Path = "/a"
+		}
+		next(w, r)
+	})
+	router.Get("/users/:id", func(w ResponseWriter, r *Request) {
+		fmt.Fprintf(w, r.RoutePath()+" "+r.PathParams["id"])
+	})
+	router.Get("/a", (*Context).A)
+
+	rw, req := newTestRequest("GET", "/users/3")
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "/users/:id 3", 200)
+
+	rw, req = newTestRequest("GET", "/users/3?rewrite=1")
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "context-A", 200)
+}
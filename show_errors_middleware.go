@@ -2,7 +2,9 @@ package web
 
 import (
 	"bufio"
+	"bytes"
 	"html/template"
+	"io"
 	"net/http"
 	"os"
 	"runtime"
@@ -19,6 +21,7 @@ func ShowErrorsMiddleware(rw ResponseWriter, req *Request, next NextMiddlewareFu
 			stack = stack[:runtime.Stack(stack, false)]
 
 			renderPrettyError(rw, req, err, stack)
+			req.PanicRecovered(err)
 		}
 	}()
 
@@ -27,15 +30,25 @@ func ShowErrorsMiddleware(rw ResponseWriter, req *Request, next NextMiddlewareFu
 
 func renderPrettyError(rw ResponseWriter, req *Request, err interface{}, stack []byte) {
 	_, filePath, line, _ := runtime.Caller(5)
+	var lines map[int]string
+
+	// Template errors are more useful with the template's source than with the renderer's.
+	if terr, ok := err.(*TemplateError); ok && terr.source != nil {
+		filePath, line = terr.Name, terr.Line
+		lines = readErrorLines(bytes.NewReader(terr.source), line)
+	} else {
+		lines = readErrorFileLines(filePath, line)
+	}
 
 	data := map[string]interface{}{
-		"Error":    err,
-		"Stack":    string(stack),
-		"Params":   req.URL.Query(),
-		"Method":   req.Method,
-		"FilePath": filePath,
-		"Line":     line,
-		"Lines":    readErrorFileLines(filePath, line),
+		"Error":     err,
+		"Stack":     string(stack),
+		"Params":    req.URL.Query(),
+		"Method":    req.Method,
+		"RequestID": req.RequestID(),
+		"FilePath":  filePath,
+		"Line":      line,
+		"Lines":     lines,
 	}
 
 	rw.Header().Set("Content-Type", "text/html")
@@ -55,11 +68,16 @@ func readErrorFileLines(filePath string, errorLine int) map[int]string {
 
 	defer file.Close()
 
-	reader := bufio.NewReader(file)
+	return readErrorLines(file, errorLine)
+}
+
+func readErrorLines(r io.Reader, errorLine int) map[int]string {
+	lines := make(map[int]string)
+	reader := bufio.NewReader(r)
 	currentLine := 0
 	for {
 		line, err := reader.ReadString('\n')
-		if err != nil || currentLine > errorLine+5 {
+		if (err != nil && line == "") || currentLine > errorLine+5 {
 			break
 		}
 
@@ -68,6 +86,10 @@ func readErrorFileLines(filePath string, errorLine int) map[int]string {
 		if currentLine >= errorLine-5 {
 			lines[currentLine] = strings.Replace(line, "\n", "", -1)
 		}
+
+		if err != nil {
+			break
+		}
 	}
 
 	return lines
@@ -176,6 +198,7 @@ const panicPageTpl string = `
       <pre class="stack">{{ .Stack }}</pre>
       <h2>Request</h2>
       <p><strong>Method:</strong> {{ .Method }}</p>
+      {{ if .RequestID }}<p><strong>Request ID:</strong> {{ .RequestID }}</p>{{ end }}
       <h3>Parameters:</h3>
       <ul>
         {{ range $key, $value := .Params }}
@@ -1,17 +1,65 @@
 package web
 
 import (
+	"crypto/sha256"
+	"embed"
+	"encoding/base64"
+	"html/template"
+	"io"
+	"io/fs"
+	"mime"
 	"net/http"
-	"path/filepath"
+	"net/url"
+	"path"
+	"sort"
 	"strings"
+	"time"
 )
 
 // StaticOption configures how StaticMiddlewareDir handles url paths and index files for directories.
 // If set, Prefix is removed from the start of the url path before attempting to serve a directory or file.
 // If set, IndexFile is the index file to serve when the url path maps to a directory.
+// If set, IndexFiles are more index files to try, in order, after IndexFile.
+// If set, DirectoryListing lists directories that have no index file, as HTML or (if the request prefers it) JSON.
+// If set, HideDotFiles refuses to serve, or list, files and directories whose names start with a ".".
+// If set, SPAFallback is the file (eg, "index.html") to serve for GET requests that accept text/html but match
+// neither a file nor a route, so that a single-page app can handle its own client-side routes. Url paths starting
+// with any of SPAExclude (eg, "/api/") never fall back.
+// If set, CacheRules choose the caching headers for each file; the first rule that matches is used.
+// If set, Precompressed are the content codings (eg, "br" and "gzip") of precompressed copies of files to look
+// for, in order of preference. See precompressedExtensions for the file extensions of each.
+// Immutable and ModTime only apply to StaticMiddlewareFromFS; see there.
 type StaticOption struct {
-	Prefix    string
-	IndexFile string
+	Prefix           string
+	IndexFile        string
+	IndexFiles       []string
+	DirectoryListing bool
+	HideDotFiles     bool
+	SPAFallback      string
+	SPAExclude       []string
+	CacheRules       []StaticCacheRule
+	Precompressed    []string
+	Immutable        bool
+	ModTime          time.Time
+}
+
+// StaticCacheRule sets the caching headers for the static files it matches.
+// Pattern is a path.Match pattern (eg, "*.js" or "/assets/*"). A pattern containing a "/" is matched against the
+// file's whole path (with Prefix removed), otherwise it's matched against the file's name.
+// If set, CacheControl is sent as the Cache-Control header.
+// If set, Expires is how long after the request to send as the Expires header.
+type StaticCacheRule struct {
+	Pattern      string
+	CacheControl string
+	Expires      time.Duration
+}
+
+// precompressedExtensions are the extensions of precompressed copies of static files, by content coding.
+// Other content codings use the coding itself as the extension.
+var precompressedExtensions = map[string]string{
+	"br":   ".br",
+	"gzip": ".gz",
+	"zstd": ".zst",
 }
 
 // StaticMiddleware is the same as StaticMiddlewareFromDir, but accepts a
@@ -22,67 +70,327 @@ func StaticMiddleware(path string, option ...StaticOption) func(ResponseWriter,
 
 // StaticMiddlewareFromDir returns a middleware that serves static files from the specified http.FileSystem.
 // This middleware is great for development because each file is read from disk each time and no
-// special caching or cache headers are sent.
+// special caching or cache headers are sent, unless you set CacheRules.
 //
 // If a path is requested which maps to a folder with an index.html folder on your filesystem,
 // then that index.html file will be served.
+//
+// If option.Precompressed is set, and there's a precompressed copy of the file next to it (eg, app.js.br for
+// app.js) in a content coding that the request accepts, that copy is served instead, with the original file's
+// Content-Type.
 func StaticMiddlewareFromDir(dir http.FileSystem, options ...StaticOption) func(ResponseWriter, *Request, NextMiddlewareFunc) {
 	var option StaticOption
 	if len(options) > 0 {
 		option = options[0]
 	}
-	return func(w ResponseWriter, req *Request, next NextMiddlewareFunc) {
-		if req.Method != "GET" && req.Method != "HEAD" {
+	s := &staticServer{dir: dir, option: option}
+	return s.serve
+}
+
+// StaticMiddlewareFromFS is like StaticMiddlewareFromDir, but serves files from an fs.FS, such as an embed.FS.
+//
+// If option.Immutable is set, or fsys is an embed.FS, the files are assumed never to change: each one is hashed
+// once, now, and served with a strong ETag so that clients can revalidate cheaply. Files with no ModTime (as in an
+// embed.FS) are served with a Last-Modified of option.ModTime, or of when this was called if that isn't set.
+func StaticMiddlewareFromFS(fsys fs.FS, options ...StaticOption) func(ResponseWriter, *Request, NextMiddlewareFunc) {
+	var option StaticOption
+	if len(options) > 0 {
+		option = options[0]
+	}
+	if option.ModTime.IsZero() {
+		option.ModTime = time.Now()
+	}
+	s := &staticServer{dir: http.FS(fsys), option: option}
+	if _, ok := fsys.(embed.FS); ok || option.Immutable {
+		s.etags = hashFiles(fsys)
+	}
+	return s.serve
+}
+
+type staticServer struct {
+	dir    http.FileSystem
+	option StaticOption
+	etags  map[string]string // By cleaned path. nil unless the files are immutable.
+	assets *AssetManifest    // If set, fingerprinted names are resolved with it.
+}
+
+func (s *staticServer) serve(w ResponseWriter, req *Request, next NextMiddlewareFunc) {
+	if req.Method != "GET" && req.Method != "HEAD" {
+		next(w, req)
+		return
+	}
+
+	option := s.option
+	file := req.URL.Path
+	if option.Prefix != "" {
+		if !strings.HasPrefix(file, option.Prefix) {
 			next(w, req)
 			return
 		}
+		file = file[len(option.Prefix):]
+	}
+	if option.HideDotFiles && hasDotSegment(file) {
+		next(w, req)
+		return
+	}
 
-		file := req.URL.Path
-		if option.Prefix != "" {
-			if !strings.HasPrefix(file, option.Prefix) {
-				next(w, req)
-				return
-			}
-			file = file[len(option.Prefix):]
+	fingerprinted := false
+	if s.assets != nil {
+		if original, ok := s.assets.resolve(file); ok {
+			file, fingerprinted = original, true
 		}
+	}
 
-		f, err := dir.Open(file)
-		if err != nil {
-			next(w, req)
+	f, fi := s.open(file)
+
+	// If the file is a directory, try to serve an index file.
+	// If no index is available, DO NOT serve the directory to avoid
+	// Content-Length issues. Simply skip to the next middleware, and return
+	// a 404 if no route with the same name is handled, unless listing directories.
+	if f != nil && fi.IsDir() {
+		dir := f
+		defer dir.Close()
+		f = nil
+		for _, index := range s.indexFiles() {
+			if f, fi = s.open(path.Join(file, index)); f != nil && !fi.IsDir() {
+				file = path.Join(file, index)
+				break
+			}
+			if f != nil {
+				f.Close()
+				f = nil
+			}
+		}
+		if f == nil && option.DirectoryListing {
+			s.listDirectory(w, req, dir)
 			return
 		}
-		defer f.Close()
+	}
 
-		fi, err := f.Stat()
-		if err != nil {
-			next(w, req)
-			return
+	if f == nil && s.shouldFallBack(req) {
+		file = option.SPAFallback
+		f, fi = s.open(file)
+	}
+	if f == nil || fi.IsDir() {
+		if f != nil {
+			f.Close()
 		}
+		next(w, req)
+		return
+	}
+	defer f.Close()
 
-		// If the file is a directory, try to serve an index file.
-		// If no index is available, DO NOT serve the directory to avoid
-		// Content-Length issues. Simply skip to the next middleware, and return
-		// a 404 if no route with the same name is handled. 
-		if fi.IsDir() {
-			if option.IndexFile != "" {
-				file = filepath.Join(file, option.IndexFile)
-				f, err = dir.Open(file)
-				if err != nil {
-					next(w, req)
-					return
-				}
-				defer f.Close()
-
-				fi, err = f.Stat()
-				if err != nil || fi.IsDir() {
-					next(w, req)
-					return
-				}
-			} else {
-				next(w, req)
-				return
-			}
+	s.setCacheHeaders(w, file)
+	if fingerprinted {
+		setAssetCacheHeaders(w)
+	}
+	if len(option.Precompressed) > 0 {
+		if cf, cfi := s.openPrecompressed(w, req, file, f); cf != nil {
+			defer cf.Close()
+			f, fi = cf, cfi
 		}
-		http.ServeContent(w, req.Request, file, fi.ModTime(), f)
 	}
+	modTime := fi.ModTime()
+	if modTime.IsZero() {
+		modTime = option.ModTime
+	}
+	http.ServeContent(w, req.Request, file, modTime, f)
+}
+
+// open opens and stats name, returning a nil file if either fails.
+func (s *staticServer) open(name string) (http.File, fs.FileInfo) {
+	// http.FS refuses paths with a trailing slash, so clean them as http.Dir does.
+	f, err := s.dir.Open(path.Clean("/" + name))
+	if err != nil {
+		return nil, nil
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil
+	}
+	return f, fi
+}
+
+func (s *staticServer) indexFiles() []string {
+	if s.option.IndexFile == "" {
+		return s.option.IndexFiles
+	}
+	return append([]string{s.option.IndexFile}, s.option.IndexFiles...)
+}
+
+// shouldFallBack returns whether req should get the single-page app's fallback file.
+func (s *staticServer) shouldFallBack(req *Request) bool {
+	if s.option.SPAFallback == "" || req.IsRouted() {
+		return false
+	}
+	for _, prefix := range s.option.SPAExclude {
+		if strings.HasPrefix(req.URL.Path, prefix) {
+			return false
+		}
+	}
+	if !strings.Contains(req.Header.Get("Accept"), "text/html") || req.Negotiate("text/html") == "" {
+		return false
+	}
+	if req.rootRouter != nil {
+		if route, _ := calculateRoute(req.rootRouter, req); route != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// staticDirEntry is an entry of a JSON directory listing.
+type staticDirEntry struct {
+	Name    string    `json:"name"`
+	IsDir   bool      `json:"isDir"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+var directoryListingTemplate = template.Must(template.New("").Funcs(template.FuncMap{"pathEscape": url.PathEscape}).Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Index of {{.Path}}</title></head>
+<body>
+<h1>Index of {{.Path}}</h1>
+<ul>
+{{- if ne .Path "/"}}
+<li><a href="../">../</a></li>
+{{- end}}
+{{- range .Entries}}
+<li><a href="{{pathEscape .Name}}{{if .IsDir}}/{{end}}">{{.Name}}{{if .IsDir}}/{{end}}</a></li>
+{{- end}}
+</ul>
+</body>
+</html>
+`))
+
+// listDirectory renders the entries of dir as HTML or JSON, depending on the request's Accept header.
+func (s *staticServer) listDirectory(w ResponseWriter, req *Request, dir http.File) {
+	// Relative links only work from the directory's canonical url, with a trailing slash.
+	if !strings.HasSuffix(req.URL.Path, "/") {
+		u := *req.URL
+		u.Path += "/"
+		Redirect(w, req, u.RequestURI(), http.StatusMovedPermanently)
+		return
+	}
+
+	infos, err := dir.Readdir(-1)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	entries := make([]staticDirEntry, 0, len(infos))
+	for _, fi := range infos {
+		if s.option.HideDotFiles && strings.HasPrefix(fi.Name(), ".") {
+			continue
+		}
+		entries = append(entries, staticDirEntry{Name: fi.Name(), IsDir: fi.IsDir(), Size: fi.Size(), ModTime: fi.ModTime()})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+
+	if req.Negotiate("text/html", "application/json") == "application/json" {
+		JSON(w, http.StatusOK, entries)
+		return
+	}
+	HTML(w, http.StatusOK, directoryListingTemplate, "", map[string]interface{}{"Path": req.URL.Path, "Entries": entries})
+}
+
+// hasDotSegment returns whether any segment of the url path p starts with a ".".
+func hasDotSegment(p string) bool {
+	for _, segment := range strings.Split(p, "/") {
+		if strings.HasPrefix(segment, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// setCacheHeaders sets the ETag and the headers of the first matching cache rule for file.
+func (s *staticServer) setCacheHeaders(w ResponseWriter, file string) {
+	file = path.Clean("/" + file)
+	if etag, ok := s.etags[file]; ok {
+		w.Header().Set("ETag", etag)
+	}
+	for _, rule := range s.option.CacheRules {
+		name := path.Base(file)
+		if strings.Contains(rule.Pattern, "/") {
+			name = file
+		}
+		if ok, _ := path.Match(rule.Pattern, name); !ok {
+			continue
+		}
+		if rule.CacheControl != "" {
+			w.Header().Set("Cache-Control", rule.CacheControl)
+		}
+		if rule.Expires != 0 {
+			w.Header().Set("Expires", time.Now().Add(rule.Expires).UTC().Format(http.TimeFormat))
+		}
+		return
+	}
+}
+
+// openPrecompressed returns the best precompressed copy of file that req accepts, and sets the headers to serve
+// it with. It returns a nil file if there isn't one.
+func (s *staticServer) openPrecompressed(w ResponseWriter, req *Request, file string, original http.File) (http.File, fs.FileInfo) {
+	w.Header().Add("Vary", "Accept-Encoding")
+	encoding := req.negotiateCompression(s.option.Precompressed)
+	if encoding == "" || encoding == "identity" {
+		return nil, nil
+	}
+
+	ext, ok := precompressedExtensions[encoding]
+	if !ok {
+		ext = "." + encoding
+	}
+	f, err := s.dir.Open(file + ext)
+	if err != nil {
+		return nil, nil
+	}
+	fi, err := f.Stat()
+	if err != nil || fi.IsDir() {
+		f.Close()
+		return nil, nil
+	}
+
+	header := w.Header()
+	if header.Get("Content-Type") == "" {
+		contentType := mime.TypeByExtension(path.Ext(file))
+		if contentType == "" {
+			var buf [512]byte
+			n, _ := io.ReadFull(original, buf[:])
+			contentType = http.DetectContentType(buf[:n])
+		}
+		header.Set("Content-Type", contentType)
+	}
+	header.Set("Content-Encoding", encoding)
+	// The copy is a different representation, so it mustn't share the original's ETag.
+	if etag, ok := s.etags[path.Clean("/"+file+ext)]; ok {
+		header.Set("ETag", etag)
+	} else {
+		header.Del("ETag")
+	}
+	return f, fi
+}
+
+// hashFiles returns strong ETags for every file in fsys, by path. Files that can't be read are skipped.
+func hashFiles(fsys fs.FS) map[string]string {
+	etags := make(map[string]string)
+	fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		f, err := fsys.Open(name)
+		if err != nil {
+			return nil
+		}
+		defer f.Close()
+		h := sha256.New()
+		if _, err := io.Copy(h, f); err != nil {
+			return nil
+		}
+		etags["/"+name] = `"` + base64.RawURLEncoding.EncodeToString(h.Sum(nil)[:18]) + `"`
+		return nil
+	})
+	return etags
 }
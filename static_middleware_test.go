@@ -1,13 +1,21 @@
 package web
 
 import (
+	"embed"
+	"github.com/stretchr/testify/assert"
 	"io/ioutil"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 )
 
+//go:embed router_setup.go
+var testEmbedFS embed.FS
+
 func TestStaticMiddleware(t *testing.T) {
 	currentRoot, _ := os.Getwd()
 
@@ -101,6 +109,81 @@ func TestStaticMiddlewareOptionPrefix(t *testing.T) {
 	assertResponse(t, rw, strings.TrimSpace(routerSetupBody()), 200)
 }
 
+func TestStaticMiddlewareFromFS(t *testing.T) {
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	fsys := fstest.MapFS{
+		"app.js":          {Data: []byte("alert(1)"), ModTime: modTime},
+		"assets/logo.svg": {Data: []byte("<svg/>")},
+		"index.html":      {Data: []byte("home")},
+	}
+
+	router := New(Context{})
+	router.Middleware(StaticMiddlewareFromFS(fsys, StaticOption{
+		IndexFile: "index.html",
+		ModTime:   modTime.Add(time.Hour),
+		CacheRules: []StaticCacheRule{
+			{Pattern: "/assets/*", CacheControl: "public, max-age=31536000, immutable"},
+			{Pattern: "*.js", CacheControl: "public, max-age=60", Expires: time.Minute},
+		},
+	}))
+
+	rw, req := newTestRequest("GET", "/app.js")
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "alert(1)", 200)
+	assert.Equal(t, "public, max-age=60", rw.Header().Get("Cache-Control"))
+	expires, err := http.ParseTime(rw.Header().Get("Expires"))
+	assert.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), expires, 2*time.Second)
+	assert.Equal(t, modTime.Format(http.TimeFormat), rw.Header().Get("Last-Modified"))
+	assert.Equal(t, "", rw.Header().Get("ETag"))
+
+	// Files without a ModTime get the fallback:
+	rw, req = newTestRequest("GET", "/assets/logo.svg")
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "<svg/>", 200)
+	assert.Equal(t, "public, max-age=31536000, immutable", rw.Header().Get("Cache-Control"))
+	assert.Equal(t, "", rw.Header().Get("Expires"))
+	assert.Equal(t, modTime.Add(time.Hour).Format(http.TimeFormat), rw.Header().Get("Last-Modified"))
+
+	rw, req = newTestRequest("GET", "/")
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "home", 200)
+	assert.Equal(t, "", rw.Header().Get("Cache-Control"))
+
+	rw, req = newTestRequest("GET", "/missing.js")
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "Not Found", 404)
+}
+
+func TestStaticMiddlewareFromFSImmutable(t *testing.T) {
+	router := New(Context{})
+	router.Middleware(StaticMiddlewareFromFS(testEmbedFS, StaticOption{Prefix: "/src"}))
+
+	rw, req := newTestRequest("GET", "/src/"+testFilename())
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, strings.TrimSpace(routerSetupBody()), 200)
+	etag := rw.Header().Get("ETag")
+	assert.Regexp(t, `^"[A-Za-z0-9_-]{24}"$`, etag)
+	assert.NotEqual(t, "", rw.Header().Get("Last-Modified"))
+
+	rw, req = newTestRequest("GET", "/src/"+testFilename())
+	req.Header.Set("If-None-Match", etag)
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "", http.StatusNotModified)
+
+	// Immutable does the same for any fs.FS:
+	fsys := fstest.MapFS{"a.txt": {Data: []byte("a")}, "b.txt": {Data: []byte("b")}}
+	router = New(Context{})
+	router.Middleware(StaticMiddlewareFromFS(fsys, StaticOption{Immutable: true}))
+	rw, req = newTestRequest("GET", "/a.txt")
+	router.ServeHTTP(rw, req)
+	etagA := rw.Header().Get("ETag")
+	rw, req = newTestRequest("GET", "/b.txt")
+	router.ServeHTTP(rw, req)
+	assert.NotEqual(t, "", etagA)
+	assert.NotEqual(t, etagA, rw.Header().Get("ETag"))
+}
+
 func testFilename() string {
 	return "router_setup.go"
 }
@@ -109,3 +192,161 @@ func routerSetupBody() string {
 	fileBytes, _ := ioutil.ReadFile(testFilename())
 	return string(fileBytes)
 }
+
+func TestStaticMiddlewareOptionPrecompressed(t *testing.T) {
+	fsys := fstest.MapFS{
+		"app.js":       {Data: []byte("alert('hello, world')")},
+		"app.js.br":    {Data: []byte("brotli bytes")},
+		"app.js.gz":    {Data: []byte("gzip bytes")},
+		"data":         {Data: []byte("<html>data</html>")},
+		"data.gz":      {Data: []byte("gzip data")},
+		"style.css":    {Data: []byte("body {}")},
+		"style.css.gz": {Data: []byte("gzip css")},
+	}
+	router := New(Context{})
+	router.Middleware(StaticMiddlewareFromFS(fsys, StaticOption{Precompressed: []string{"br", "gzip"}, Immutable: true}))
+
+	for _, tc := range []struct {
+		path, acceptEncoding, body, encoding, contentType string
+	}{
+		{"/app.js", "gzip, deflate, br", "brotli bytes", "br", "text/javascript; charset=utf-8"},
+		{"/app.js", "gzip", "gzip bytes", "gzip", "text/javascript; charset=utf-8"},
+		{"/app.js", "br;q=0.5, gzip", "gzip bytes", "gzip", "text/javascript; charset=utf-8"},
+		{"/app.js", "", "alert('hello, world')", "", "text/javascript; charset=utf-8"},
+		{"/style.css", "br", "body {}", "", "text/css; charset=utf-8"},
+		{"/data", "gzip", "gzip data", "gzip", "text/html; charset=utf-8"},
+	} {
+		rw, req := newTestRequest("GET", tc.path)
+		if tc.acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", tc.acceptEncoding)
+		}
+		router.ServeHTTP(rw, req)
+		assertResponse(t, rw, tc.body, 200)
+		assert.Equal(t, tc.encoding, rw.Header().Get("Content-Encoding"), tc.path+" "+tc.acceptEncoding)
+		assert.Equal(t, tc.contentType, rw.Header().Get("Content-Type"), tc.path+" "+tc.acceptEncoding)
+		assert.Equal(t, "Accept-Encoding", rw.Header().Get("Vary"))
+	}
+
+	// Each representation has its own ETag:
+	rw, req := newTestRequest("GET", "/app.js")
+	router.ServeHTTP(rw, req)
+	identityETag := rw.Header().Get("ETag")
+	rw, req = newTestRequest("GET", "/app.js")
+	req.Header.Set("Accept-Encoding", "br")
+	router.ServeHTTP(rw, req)
+	assert.NotEqual(t, identityETag, rw.Header().Get("ETag"))
+
+	// Ranges apply to the precompressed copy:
+	rw, req = newTestRequest("GET", "/app.js")
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("Range", "bytes=0-3")
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "gzip", http.StatusPartialContent)
+	assert.Equal(t, "gzip", rw.Header().Get("Content-Encoding"))
+}
+
+func TestStaticMiddlewareOptionSPAFallback(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.html":  {Data: []byte("spa")},
+		"app.js":      {Data: []byte("app")},
+		"docs/a.html": {Data: []byte("a")},
+	}
+	router := New(Context{})
+	router.Middleware(StaticMiddlewareFromFS(fsys, StaticOption{
+		IndexFile:   "index.html",
+		SPAFallback: "index.html",
+		SPAExclude:  []string{"/api/"},
+	}))
+	router.Get("/api/users", func(rw ResponseWriter, req *Request) {
+		Text(rw, 200, "users")
+	})
+	router.Get("/status", func(rw ResponseWriter, req *Request) {
+		Text(rw, 200, "ok")
+	})
+
+	for _, tc := range []struct {
+		method, path, accept, body string
+		code                       int
+	}{
+		{"GET", "/users/42/edit", "text/html,application/xhtml+xml,*/*;q=0.8", "spa", 200},
+		{"HEAD", "/users/42/edit", "text/html", "", 200},
+		{"GET", "/app.js", "text/html", "app", 200},
+		{"GET", "/status", "text/html", "ok", 200},
+		{"GET", "/api/users", "text/html", "users", 200},
+		{"GET", "/api/missing", "text/html", "Not Found", 404},
+		{"GET", "/missing.js", "*/*", "Not Found", 404},
+		{"GET", "/users/42/edit", "application/json", "Not Found", 404},
+		{"GET", "/users/42/edit", "text/html;q=0", "Not Found", 404},
+		{"POST", "/users/42/edit", "text/html", "Not Found", 404},
+	} {
+		rw, req := newTestRequest(tc.method, tc.path)
+		req.Header.Set("Accept", tc.accept)
+		router.ServeHTTP(rw, req)
+		assert.Equal(t, tc.code, rw.Code, tc.method+" "+tc.path+" "+tc.accept)
+		assert.Equal(t, tc.body, strings.TrimSpace(rw.Body.String()), tc.method+" "+tc.path+" "+tc.accept)
+	}
+}
+
+func TestStaticMiddlewareOptionDirectories(t *testing.T) {
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	fsys := fstest.MapFS{
+		"docs/readme.txt":    {Data: []byte("readme"), ModTime: modTime},
+		"docs/guide/a.html":  {Data: []byte("a")},
+		"docs/.secret":       {Data: []byte("secret")},
+		"docs/a&b.txt":       {Data: []byte("ab")},
+		"site/default.htm":   {Data: []byte("default")},
+		"site/index.html/x":  {Data: []byte("x")},
+		".git/config":        {Data: []byte("config")},
+		"empty/.placeholder": {Data: []byte("")},
+	}
+	router := New(Context{})
+	router.Middleware(StaticMiddlewareFromFS(fsys, StaticOption{
+		IndexFile:        "index.html",
+		IndexFiles:       []string{"default.htm"},
+		DirectoryListing: true,
+		HideDotFiles:     true,
+	}))
+
+	// Index files are tried in order, skipping directories:
+	rw, req := newTestRequest("GET", "/site/")
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "default", 200)
+
+	// Listings redirect to the canonical url:
+	rw, req = newTestRequest("GET", "/docs")
+	router.ServeHTTP(rw, req)
+	assert.Equal(t, http.StatusMovedPermanently, rw.Code)
+	assert.Equal(t, "/docs/", rw.Header().Get("Location"))
+
+	rw, req = newTestRequest("GET", "/docs/")
+	router.ServeHTTP(rw, req)
+	assert.Equal(t, 200, rw.Code)
+	assert.Equal(t, "text/html; charset=utf-8", rw.Header().Get("Content-Type"))
+	body := rw.Body.String()
+	assert.Contains(t, body, "<title>Index of /docs/</title>")
+	assert.Contains(t, body, `<li><a href="../">../</a></li>`)
+	assert.Contains(t, body, `<li><a href="a&amp;b.txt">a&amp;b.txt</a></li>`)
+	assert.Contains(t, body, `<li><a href="guide/">guide/</a></li>`)
+	assert.Contains(t, body, `<li><a href="readme.txt">readme.txt</a></li>`)
+	assert.NotContains(t, body, "secret")
+
+	rw, req = newTestRequest("GET", "/docs/")
+	req.Header.Set("Accept", "application/json")
+	router.ServeHTTP(rw, req)
+	assert.Equal(t, "application/json; charset=utf-8", rw.Header().Get("Content-Type"))
+	assert.Equal(t, `[{"name":"a\u0026b.txt","isDir":false,"size":2,"modTime":"0001-01-01T00:00:00Z"},`+
+		`{"name":"guide","isDir":true,"size":0,"modTime":"0001-01-01T00:00:00Z"},`+
+		`{"name":"readme.txt","isDir":false,"size":6,"modTime":"2020-01-02T03:04:05Z"}]`, strings.TrimSpace(rw.Body.String()))
+
+	// Dot files are hidden:
+	for _, p := range []string{"/docs/.secret", "/.git/config", "/.git/"} {
+		rw, req = newTestRequest("GET", p)
+		router.ServeHTTP(rw, req)
+		assertResponse(t, rw, "Not Found", 404)
+	}
+
+	rw, req = newTestRequest("GET", "/empty/")
+	router.ServeHTTP(rw, req)
+	assert.Equal(t, 200, rw.Code)
+	assert.NotContains(t, rw.Body.String(), "placeholder")
+}
@@ -0,0 +1,217 @@
+package web
+
+import (
+	"bytes"
+	"html/template"
+	"io/fs"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// TemplateOptions configures a TemplateRenderer. Every field is optional.
+type TemplateOptions struct {
+	// Extension of template files. Defaults to ".html".
+	Extension string
+
+	// LayoutDir holds layouts. A layout is an ordinary template that includes the page with {{ template "content" . }}.
+	// Pages can override {{ block }}s in their layout by {{ define }}ing them. Defaults to "layouts".
+	LayoutDir string
+
+	// PartialDir holds partials, which are available to every page and layout by their path, without the extension.
+	// Eg, {{ template "partials/header" . }}. Defaults to "partials".
+	PartialDir string
+
+	// DefaultLayout is the layout Render uses, eg "application". If empty, pages are rendered without one.
+	DefaultLayout string
+
+	// Funcs are added to every template, along with "asset" (see AssetPath), which they can override.
+	Funcs template.FuncMap
+
+	// Development makes the renderer check whether template files have changed on each render, and re-parse
+	// them if so. Otherwise templates are parsed once and cached.
+	Development bool
+}
+
+// TemplateRenderer renders html/template pages, with layouts and partials, from a directory or fs.FS.
+type TemplateRenderer struct {
+	fsys    fs.FS
+	options TemplateOptions
+
+	mu    sync.RWMutex
+	cache map[string]*templateSet
+}
+
+type templateSet struct {
+	tpl   *template.Template
+	entry string // the template to execute
+	stamp string // in development mode, the names and modification times of the files that were parsed
+}
+
+// TemplateError is what the renderer panics with when a template fails to parse or execute.
+// ShowErrorsMiddleware renders it with the template source around the failing line.
+type TemplateError struct {
+	// Name is the path of the failing template, eg "users/show.html". Line is 0 if it couldn't be determined.
+	Name string
+	Line int
+	Err  error
+
+	source []byte
+}
+
+func (e *TemplateError) Error() string {
+	return e.Err.Error()
+}
+
+// NewTemplateRenderer returns a renderer that loads templates from fsys, which can be an embed.FS.
+func NewTemplateRenderer(fsys fs.FS, options TemplateOptions) *TemplateRenderer {
+	if options.Extension == "" {
+		options.Extension = ".html"
+	}
+	if options.LayoutDir == "" {
+		options.LayoutDir = "layouts"
+	}
+	if options.PartialDir == "" {
+		options.PartialDir = "partials"
+	}
+	return &TemplateRenderer{fsys: fsys, options: options, cache: make(map[string]*templateSet)}
+}
+
+// NewTemplateRendererFromDir returns a renderer that loads templates from the directory dir on disk.
+func NewTemplateRendererFromDir(dir string, options TemplateOptions) *TemplateRenderer {
+	return NewTemplateRenderer(os.DirFS(dir), options)
+}
+
+// Render renders the page name (a path without the extension, eg "users/show") in the default layout.
+// Templates are executed before anything is written, so failures can be handled by your Error handler,
+// or displayed by ShowErrorsMiddleware.
+func (t *TemplateRenderer) Render(rw ResponseWriter, status int, name string, data interface{}) {
+	t.RenderLayout(rw, status, t.options.DefaultLayout, name, data)
+}
+
+// RenderLayout is like Render, but uses the given layout. An empty layout renders the page by itself.
+func (t *TemplateRenderer) RenderLayout(rw ResponseWriter, status int, layout string, name string, data interface{}) {
+	set, err := t.lookup(layout, name)
+	if err != nil {
+		panic(t.templateError(err))
+	}
+
+	var buf bytes.Buffer
+	if err := set.tpl.ExecuteTemplate(&buf, set.entry, data); err != nil {
+		panic(t.templateError(err))
+	}
+	writeRendered(rw, status, "text/html; charset=utf-8", buf.Bytes())
+}
+
+func (t *TemplateRenderer) lookup(layout, name string) (*templateSet, error) {
+	key := layout + "\x00" + name
+
+	t.mu.RLock()
+	set := t.cache[key]
+	t.mu.RUnlock()
+	if set != nil && !t.options.Development {
+		return set, nil
+	}
+
+	files, err := t.files(layout, name)
+	if err != nil {
+		return nil, err
+	}
+
+	var stamp string
+	if t.options.Development {
+		if stamp, err = t.stamp(files); err != nil {
+			return nil, err
+		}
+	}
+	if set != nil && set.stamp == stamp {
+		return set, nil
+	}
+
+	set, err = t.parse(files, layout != "")
+	if err != nil {
+		return nil, err
+	}
+	set.stamp = stamp
+
+	t.mu.Lock()
+	t.cache[key] = set
+	t.mu.Unlock()
+	return set, nil
+}
+
+// files returns the files needed to render the page: partials first, then the layout (if any), then the page.
+func (t *TemplateRenderer) files(layout, name string) ([]string, error) {
+	files, err := fs.Glob(t.fsys, path.Join(t.options.PartialDir, "*"+t.options.Extension))
+	if err != nil {
+		return nil, err
+	}
+	if layout != "" {
+		files = append(files, path.Join(t.options.LayoutDir, layout+t.options.Extension))
+	}
+	return append(files, name+t.options.Extension), nil
+}
+
+func (t *TemplateRenderer) stamp(files []string) (string, error) {
+	var buf bytes.Buffer
+	for _, file := range files {
+		fi, err := fs.Stat(t.fsys, file)
+		if err != nil {
+			return "", err
+		}
+		buf.WriteString(file)
+		buf.WriteString(fi.ModTime().Format(time.RFC3339Nano))
+		buf.WriteString(strconv.FormatInt(fi.Size(), 10))
+	}
+	return buf.String(), nil
+}
+
+func (t *TemplateRenderer) parse(files []string, hasLayout bool) (*templateSet, error) {
+	page := files[len(files)-1]
+	tpl := template.New("").Funcs(template.FuncMap{"asset": AssetPath}).Funcs(t.options.Funcs)
+	for _, file := range files {
+		src, err := fs.ReadFile(t.fsys, file)
+		if err != nil {
+			return nil, err
+		}
+		name := file
+		if file != page {
+			name = file[:len(file)-len(t.options.Extension)]
+		}
+		if _, err := tpl.New(name).Parse(string(src)); err != nil {
+			return nil, err
+		}
+	}
+
+	set := &templateSet{tpl: tpl, entry: page}
+	if hasLayout {
+		if _, err := tpl.New("content").Parse(`{{ template "` + page + `" . }}`); err != nil {
+			return nil, err
+		}
+		layout := files[len(files)-2]
+		set.entry = layout[:len(layout)-len(t.options.Extension)]
+	}
+	return set, nil
+}
+
+// Parse and exec errors look like "template: users/show.html:12: ..." or "template: users/show.html:12:5: ...".
+var templateErrorRegexp = regexp.MustCompile(`template: ([^:]+):(\d+)`)
+
+func (t *TemplateRenderer) templateError(err error) *TemplateError {
+	terr := &TemplateError{Err: err}
+	if m := templateErrorRegexp.FindStringSubmatch(err.Error()); m != nil {
+		terr.Name = m[1]
+		terr.Line, _ = strconv.Atoi(m[2])
+		if path.Ext(terr.Name) != t.options.Extension {
+			terr.Name += t.options.Extension
+		}
+		terr.source, _ = fs.ReadFile(t.fsys, terr.Name)
+	}
+	if pathErr, ok := err.(*fs.PathError); ok {
+		terr.Name = pathErr.Path
+	}
+	return terr
+}
@@ -0,0 +1,106 @@
+package web
+
+import (
+	"github.com/stretchr/testify/assert"
+	"html/template"
+	"net/http"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func newTestTemplateFS() fstest.MapFS {
+	return fstest.MapFS{
+		"layouts/application.html": {Data: []byte(`<title>{{ block "title" . }}Default{{ end }}</title>{{ template "partials/nav" . }}<main>{{ template "content" . }}</main>`)},
+		"partials/nav.html":        {Data: []byte(`<nav>{{ shout "home" }}</nav>`)},
+		"users/show.html":          {Data: []byte(`{{ define "title" }}User{{ end }}Hello, {{ .Name }}`)},
+		"users/broken.html":        {Data: []byte("line one\nline two {{ .Name.Missing }}\nline three\n")},
+	}
+}
+
+func newTestTemplateRenderer(fsys fstest.MapFS, development bool) *TemplateRenderer {
+	return NewTemplateRenderer(fsys, TemplateOptions{
+		DefaultLayout: "application",
+		Development:   development,
+		Funcs:         template.FuncMap{"shout": strings.ToUpper},
+	})
+}
+
+func TestTemplateRendererLayoutsAndPartials(t *testing.T) {
+	renderer := newTestTemplateRenderer(newTestTemplateFS(), false)
+
+	router := New(Context{})
+	router.Get("/user", func(rw ResponseWriter, req *Request) {
+		renderer.Render(rw, http.StatusOK, "users/show", map[string]string{"Name": "<Bob>"})
+	})
+	router.Get("/bare", func(rw ResponseWriter, req *Request) {
+		renderer.RenderLayout(rw, http.StatusAccepted, "", "users/show", map[string]string{"Name": "Bob"})
+	})
+
+	rw, req := newTestRequest("GET", "/user")
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "<title>User</title><nav>HOME</nav><main>Hello, &lt;Bob&gt;</main>", http.StatusOK)
+	assert.Equal(t, "text/html; charset=utf-8", rw.Header().Get("Content-Type"))
+
+	rw, req = newTestRequest("GET", "/bare")
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "Hello, Bob", http.StatusAccepted)
+}
+
+func TestTemplateRendererCaching(t *testing.T) {
+	for _, development := range []bool{false, true} {
+		fsys := newTestTemplateFS()
+		renderer := newTestTemplateRenderer(fsys, development)
+
+		router := New(Context{})
+		router.Get("/user", func(rw ResponseWriter, req *Request) {
+			renderer.RenderLayout(rw, http.StatusOK, "", "users/show", map[string]string{"Name": "Bob"})
+		})
+
+		rw, req := newTestRequest("GET", "/user")
+		router.ServeHTTP(rw, req)
+		assertResponse(t, rw, "Hello, Bob", http.StatusOK)
+
+		fsys["users/show.html"] = &fstest.MapFile{Data: []byte(`Bye, {{ .Name }}`), ModTime: time.Now()}
+
+		rw, req = newTestRequest("GET", "/user")
+		router.ServeHTTP(rw, req)
+		if development {
+			assertResponse(t, rw, "Bye, Bob", http.StatusOK)
+		} else {
+			assertResponse(t, rw, "Hello, Bob", http.StatusOK)
+		}
+	}
+}
+
+func TestTemplateRendererErrors(t *testing.T) {
+	renderer := newTestTemplateRenderer(newTestTemplateFS(), false)
+
+	router := New(Context{})
+	router.Middleware(ShowErrorsMiddleware)
+	router.Get("/broken", func(rw ResponseWriter, req *Request) {
+		renderer.Render(rw, http.StatusOK, "users/broken", map[string]string{"Name": "Bob"})
+	})
+	router.Get("/missing", func(rw ResponseWriter, req *Request) {
+		defer func() {
+			terr, ok := recover().(*TemplateError)
+			if assert.True(t, ok) {
+				assert.Equal(t, "users/missing.html", terr.Name)
+			}
+		}()
+		renderer.Render(rw, http.StatusOK, "users/missing", nil)
+	})
+
+	rw, req := newTestRequest("GET", "/broken")
+	router.ServeHTTP(rw, req)
+	assert.Equal(t, http.StatusInternalServerError, rw.Code)
+	body := rw.Body.String()
+	assert.Contains(t, body, "users/broken.html:2")
+	assert.Contains(t, body, "line two {{ .Name.Missing }}")
+	assert.Contains(t, body, "line three")
+	assert.NotContains(t, body, "Hello")
+
+	rw, req = newTestRequest("GET", "/missing")
+	router.ServeHTTP(rw, req)
+}
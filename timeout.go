@@ -0,0 +1,429 @@
+package web
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultTimeoutStatus is the status of the response to a request that times out, if there's no Error handler.
+// Set it to http.StatusGatewayTimeout if you prefer.
+var DefaultTimeoutStatus = http.StatusServiceUnavailable
+
+// DefaultTimeoutResponse is the default text rendered when a request times out and no Error handlers are present.
+var DefaultTimeoutResponse = "Service Unavailable"
+
+// TimeoutError is passed to Error handlers when a request takes longer than its router's Timeout.
+type TimeoutError struct {
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return "web: request timed out after " + e.Timeout.String()
+}
+
+// StatusCode returns DefaultTimeoutStatus.
+func (e *TimeoutError) StatusCode() int {
+	return DefaultTimeoutStatus
+}
+
+// TimeoutReporter is a PanicReporter that wants to know about requests that time out. If PanicHandler implements
+// it, Timeout is called for each one.
+type TimeoutReporter interface {
+	Timeout(req *Request, timeout time.Duration)
+}
+
+func reportTimeout(req *Request, timeout time.Duration) {
+	if reporter, ok := PanicHandler.(TimeoutReporter); ok {
+		reporter.Timeout(req, timeout)
+	}
+}
+
+// routeTimeout returns the timeout of the route, if it has one, or else of the deepest router that has one.
+func routeTimeout(route *route, routers []*Router) time.Duration {
+	if route.timeout > 0 {
+		return route.timeout
+	}
+	for i := len(routers) - 1; i >= 0; i-- {
+		if routers[i].timeout > 0 {
+			return routers[i].timeout
+		}
+	}
+	return 0
+}
+
+// serveWithTimeout runs the rest of the middleware stack, and the handler, in another goroutine, with copies of req
+// and of the contexts, and a request context that has a deadline. If they finish in time, what they did to the
+// contexts is copied back. If they don't, the timeout response is sent and serveWithTimeout returns, leaving them
+// running; a timeoutResponseWriter makes sure they can't write anymore, and the Error handler and root middleware
+// keep the original contexts to themselves.
+func (closure *middlewareClosure) serveWithTimeout(rw ResponseWriter, req *Request, timeout time.Duration) {
+	ctx, cancel := context.WithCancel(req.Context())
+	defer cancel()
+	tctx := &timeoutContext{Context: ctx, deadline: time.Now().Add(timeout)}
+	inner := *req
+	inner.Request = req.Request.WithContext(tctx)
+
+	contexts := closure.Contexts
+	closure.Contexts = copyContexts(contexts)
+	inner.rootContext = closure.Contexts[0]
+	inner.targetContext = closure.Contexts[len(closure.Contexts)-1]
+
+	tw := &timeoutResponseWriter{rw: rw, header: rw.Header().Clone(), ctx: tctx, hijacked: make(chan struct{})}
+	writer := withGuardedInterfaces(tw, rw)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				closure.RootRouter.handlePanic(writer, &inner, recovered)
+			}
+		}()
+		closure.Next(writer, &inner)
+	}()
+
+	finish := func() {
+		<-done
+		copyContextsBack(contexts, closure.Contexts)
+		closure.Contexts = contexts
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		finish()
+		return
+	case <-tw.hijacked:
+		// The connection is the handler's now (eg, a WebSocket), so the timeout doesn't apply anymore.
+		timer.Stop()
+		finish()
+		return
+	case <-timer.C:
+	}
+
+	// Stop writes before the handler hears about the timeout, so that it can't squeeze any more in.
+	tw.mu.Lock()
+	if tw.ctx.hijacked.Load() {
+		tw.mu.Unlock()
+		finish()
+		return
+	}
+	tw.timedOut = true
+	committed := tw.committed
+	tw.mu.Unlock()
+	tctx.timedOut.Store(true)
+	cancel()
+
+	if committed {
+		// Too late to send the timeout response. The response is cut off here.
+		reportTimeout(req, timeout)
+	} else {
+		closure.RootRouter.handlePanic(withGuardedInterfaces(&lockedResponseWriter{tw}, rw), req, &TimeoutError{Timeout: timeout})
+	}
+}
+
+// copyContexts returns copies of contexts, as made by contextsFor, that are linked to each other as the originals are.
+func copyContexts(contexts []reflect.Value) []reflect.Value {
+	copies := make([]reflect.Value, len(contexts))
+	for i, ctx := range contexts {
+		if i > 0 && ctx.Pointer() == contexts[i-1].Pointer() {
+			copies[i] = copies[i-1]
+			continue
+		}
+		copies[i] = reflect.New(ctx.Elem().Type())
+		copies[i].Elem().Set(ctx.Elem())
+		if i > 0 {
+			copies[i].Elem().Field(0).Set(copies[i-1])
+		}
+	}
+	return copies
+}
+
+// copyContextsBack copies the values of copies, as made by copyContexts, back to contexts.
+func copyContextsBack(contexts, copies []reflect.Value) {
+	for i, ctx := range contexts {
+		if i > 0 && ctx.Pointer() == contexts[i-1].Pointer() {
+			continue
+		}
+		ctx.Elem().Set(copies[i].Elem())
+		if i > 0 {
+			ctx.Elem().Field(0).Set(contexts[i-1])
+		}
+	}
+}
+
+// timeoutContext is cancelled by serveWithTimeout, rather than by its own timer, so that the timeoutResponseWriter
+// is guarded by the time the handler sees it's done.
+type timeoutContext struct {
+	context.Context
+	deadline time.Time
+	timedOut atomic.Bool
+	hijacked atomic.Bool // Once the connection is hijacked, the deadline no longer applies.
+}
+
+func (c *timeoutContext) Deadline() (time.Time, bool) {
+	if c.hijacked.Load() {
+		return c.Context.Deadline()
+	}
+	if deadline, ok := c.Context.Deadline(); ok && deadline.Before(c.deadline) {
+		return deadline, true
+	}
+	return c.deadline, true
+}
+
+func (c *timeoutContext) Err() error {
+	if err := c.Context.Err(); err != nil && c.timedOut.Load() {
+		return context.DeadlineExceeded
+	}
+	return c.Context.Err()
+}
+
+// timeoutResponseWriter guards a ResponseWriter from writes after the request timed out, when it's being (or has
+// been) used to send the timeout response. Until its first write, headers go to a separate map, so that they
+// don't get mixed with the timeout response's.
+type timeoutResponseWriter struct {
+	rw     ResponseWriter
+	header http.Header
+
+	ctx      *timeoutContext
+	hijacked chan struct{} // Closed once the connection is hijacked.
+
+	mu        sync.Mutex
+	committed bool // The header has been copied to rw, and a response started.
+	timedOut  bool
+}
+
+func (w *timeoutResponseWriter) Header() http.Header {
+	return w.header
+}
+
+// commit copies the header to rw. w.mu must be held.
+func (w *timeoutResponseWriter) commit() {
+	if w.committed {
+		return
+	}
+	w.committed = true
+	header := w.rw.Header()
+	for k := range header {
+		delete(header, k)
+	}
+	for k, v := range w.header {
+		header[k] = v
+	}
+}
+
+func (w *timeoutResponseWriter) WriteHeader(statusCode int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timedOut {
+		return
+	}
+	w.commit()
+	w.rw.WriteHeader(statusCode)
+}
+
+func (w *timeoutResponseWriter) Write(data []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	w.commit()
+	return w.rw.Write(data)
+}
+
+func (w *timeoutResponseWriter) StatusCode() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.rw.StatusCode()
+}
+
+func (w *timeoutResponseWriter) Written() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.rw.Written()
+}
+
+func (w *timeoutResponseWriter) Size() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.rw.Size()
+}
+
+func (w *timeoutResponseWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timedOut {
+		return
+	}
+	w.commit()
+	w.rw.Flush()
+}
+
+func (w *timeoutResponseWriter) CloseNotify() <-chan bool {
+	return w.rw.CloseNotify()
+}
+
+func (w *timeoutResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timedOut {
+		return nil, nil, http.ErrHandlerTimeout
+	}
+	conn, brw, err := w.rw.Hijack()
+	if err == nil {
+		// A hijacked connection is the handler's; the timeout can't send a response on it, or cut it off.
+		w.committed = true
+		w.ctx.hijacked.Store(true)
+		close(w.hijacked)
+	}
+	return conn, brw, err
+}
+
+func (w *timeoutResponseWriter) Unwrap() http.ResponseWriter {
+	return w.rw
+}
+
+// push is Push for the timeoutResponseWriters whose ResponseWriter is an http.Pusher.
+func (w *timeoutResponseWriter) push(target string, opts *http.PushOptions) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timedOut {
+		return http.ErrHandlerTimeout
+	}
+	return w.rw.(http.Pusher).Push(target, opts)
+}
+
+// readFrom is ReadFrom for the timeoutResponseWriters whose ResponseWriter is an io.ReaderFrom.
+func (w *timeoutResponseWriter) readFrom(r io.Reader) (int64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	w.commit()
+	return w.rw.(io.ReaderFrom).ReadFrom(r)
+}
+
+// lockedResponseWriter sends the timeout response to the ResponseWriter under a timeoutResponseWriter, holding its
+// lock, so that it doesn't race with the abandoned handler (eg, calling StatusCode).
+type lockedResponseWriter struct {
+	w *timeoutResponseWriter
+}
+
+// Header returns the header of the underlying ResponseWriter. Once the request timed out, the handler can't get to
+// it anymore.
+func (l *lockedResponseWriter) Header() http.Header {
+	return l.w.rw.Header()
+}
+
+func (l *lockedResponseWriter) WriteHeader(statusCode int) {
+	l.w.mu.Lock()
+	defer l.w.mu.Unlock()
+	l.w.rw.WriteHeader(statusCode)
+}
+
+func (l *lockedResponseWriter) Write(data []byte) (int, error) {
+	l.w.mu.Lock()
+	defer l.w.mu.Unlock()
+	return l.w.rw.Write(data)
+}
+
+func (l *lockedResponseWriter) StatusCode() int {
+	return l.w.StatusCode()
+}
+
+func (l *lockedResponseWriter) Written() bool {
+	return l.w.Written()
+}
+
+func (l *lockedResponseWriter) Size() int {
+	return l.w.Size()
+}
+
+func (l *lockedResponseWriter) Flush() {
+	l.w.mu.Lock()
+	defer l.w.mu.Unlock()
+	l.w.rw.Flush()
+}
+
+func (l *lockedResponseWriter) CloseNotify() <-chan bool {
+	return l.w.rw.CloseNotify()
+}
+
+func (l *lockedResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	l.w.mu.Lock()
+	defer l.w.mu.Unlock()
+	return l.w.rw.Hijack()
+}
+
+func (l *lockedResponseWriter) Unwrap() http.ResponseWriter {
+	return l.w.rw
+}
+
+func (l *lockedResponseWriter) push(target string, opts *http.PushOptions) error {
+	l.w.mu.Lock()
+	defer l.w.mu.Unlock()
+	return l.w.rw.(http.Pusher).Push(target, opts)
+}
+
+func (l *lockedResponseWriter) readFrom(r io.Reader) (int64, error) {
+	l.w.mu.Lock()
+	defer l.w.mu.Unlock()
+	return l.w.rw.(io.ReaderFrom).ReadFrom(r)
+}
+
+// guardedResponseWriter is a timeoutResponseWriter or a lockedResponseWriter.
+type guardedResponseWriter interface {
+	ResponseWriter
+	Unwrap() http.ResponseWriter
+	push(target string, opts *http.PushOptions) error
+	readFrom(r io.Reader) (int64, error)
+}
+
+// withGuardedInterfaces returns w, wrapped so that it's an http.Pusher or an io.ReaderFrom if rw, the ResponseWriter
+// it guards, is, as ServeHTTP does for the ResponseWriter it's given.
+func withGuardedInterfaces(w guardedResponseWriter, rw ResponseWriter) ResponseWriter {
+	_, isPusher := rw.(http.Pusher)
+	_, isReaderFrom := rw.(io.ReaderFrom)
+	switch {
+	case isPusher && isReaderFrom:
+		return &pushingReaderFromGuardedResponseWriter{pushingGuardedResponseWriter{w}}
+	case isPusher:
+		return &pushingGuardedResponseWriter{w}
+	case isReaderFrom:
+		return &readerFromGuardedResponseWriter{w}
+	}
+	return w
+}
+
+type pushingGuardedResponseWriter struct {
+	guardedResponseWriter
+}
+
+func (w *pushingGuardedResponseWriter) Push(target string, opts *http.PushOptions) error {
+	return w.push(target, opts)
+}
+
+type readerFromGuardedResponseWriter struct {
+	guardedResponseWriter
+}
+
+func (w *readerFromGuardedResponseWriter) ReadFrom(r io.Reader) (int64, error) {
+	return w.readFrom(r)
+}
+
+type pushingReaderFromGuardedResponseWriter struct {
+	pushingGuardedResponseWriter
+}
+
+func (w *pushingReaderFromGuardedResponseWriter) ReadFrom(r io.Reader) (int64, error) {
+	return w.readFrom(r)
+}
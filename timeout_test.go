@@ -0,0 +1,289 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type timeoutRecorder struct {
+	nullPanicReporter
+	mu       sync.Mutex
+	routes   []string
+	timeouts []time.Duration
+}
+
+func (r *timeoutRecorder) Timeout(req *Request, timeout time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.routes = append(r.routes, req.RoutePath())
+	r.timeouts = append(r.timeouts, timeout)
+}
+
+func TestRouterTimeout(t *testing.T) {
+	recorder := &timeoutRecorder{}
+	oldHandler := PanicHandler
+	PanicHandler = recorder
+	defer func() { PanicHandler = oldHandler }()
+
+	writeErr := make(chan error, 1)
+	router := New(Context{})
+	router.Middleware(func(rw ResponseWriter, req *Request, next NextMiddlewareFunc) {
+		rw.Header().Set("X-Outer", "1")
+		next(rw, req)
+	})
+	router.Timeout(20 * time.Millisecond)
+	router.Get("/fast", func(rw ResponseWriter, req *Request) {
+		_, hasDeadline := req.Context().Deadline()
+		assert.True(t, hasDeadline)
+		rw.Header().Set("X-Inner", "1")
+		rw.WriteHeader(http.StatusCreated)
+		rw.Write([]byte("fast"))
+	})
+	router.Get("/slow", func(rw ResponseWriter, req *Request) {
+		rw.Header().Set("X-Inner", "1")
+		<-req.Context().Done()
+		assert.Equal(t, context.DeadlineExceeded, req.Context().Err())
+		_, err := rw.Write([]byte("too late"))
+		writeErr <- err
+	})
+
+	rw, req := newTestRequest("GET", "/fast")
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "fast", 201)
+	assert.Equal(t, "1", rw.Header().Get("X-Outer"))
+	assert.Equal(t, "1", rw.Header().Get("X-Inner"))
+
+	rw, req = newTestRequest("GET", "/slow")
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "Service Unavailable", 503)
+	assert.Equal(t, "1", rw.Header().Get("X-Outer"))
+	assert.Equal(t, "", rw.Header().Get("X-Inner"))
+	assert.Equal(t, http.ErrHandlerTimeout, <-writeErr)
+	assert.Equal(t, "Service Unavailable", rw.Body.String()[:19])
+
+	recorder.mu.Lock()
+	assert.Equal(t, []string{"/slow"}, recorder.routes)
+	assert.Equal(t, []time.Duration{20 * time.Millisecond}, recorder.timeouts)
+	recorder.mu.Unlock()
+}
+
+func (c *AdminContext) TimeoutErrorHandler(w ResponseWriter, r *Request, err interface{}) {
+	if terr, ok := err.(*TimeoutError); ok {
+		w.WriteHeader(http.StatusGatewayTimeout)
+		w.Write([]byte("admin timeout after " + terr.Timeout.String()))
+		return
+	}
+	w.WriteHeader(http.StatusInternalServerError)
+	w.Write([]byte("admin error"))
+}
+
+func TestSubrouterTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	router := New(Context{})
+	router.Timeout(time.Hour)
+	admin := router.Subrouter(AdminContext{}, "/admin")
+	admin.Timeout(10 * time.Millisecond)
+	admin.Error((*AdminContext).TimeoutErrorHandler)
+	admin.Get("/slow", func(rw ResponseWriter, req *Request) { <-release })
+	admin.Get("/boom", (*AdminContext).ErrorAction)
+	// A single route with its own timeout:
+	router.Get("/slow", func(rw ResponseWriter, req *Request) { <-release })
+	router.RouteTimeout("GET", "/slow", 10*time.Millisecond)
+	router.Get("/action", (*Context).A)
+	admin.Get("/quick", func(rw ResponseWriter, req *Request) {
+		deadline, _ := req.Context().Deadline()
+		assert.True(t, time.Until(deadline) > time.Minute)
+		rw.Write([]byte("quick"))
+	})
+	admin.RouteTimeout("GET", "/quick", time.Hour)
+	assert.Panics(t, func() { router.RouteTimeout("POST", "/slow", time.Second) })
+
+	rw, req := newTestRequest("GET", "/admin/slow")
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "admin timeout after 10ms", 504)
+
+	// Panics still go to the error handler:
+	rw, req = newTestRequest("GET", "/admin/boom")
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "admin error", 500)
+
+	rw, req = newTestRequest("GET", "/slow")
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "Service Unavailable", 503)
+
+	rw, req = newTestRequest("GET", "/action")
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "context-A", 200)
+
+	// A route's timeout overrides its router's:
+	rw, req = newTestRequest("GET", "/admin/quick")
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "quick", 200)
+}
+
+func TestTimeoutAfterWrite(t *testing.T) {
+	var buf bytes.Buffer
+	oldHandler := PanicHandler
+	PanicHandler = logPanicReporter{log: log.New(&buf, "", 0)}
+	defer func() { PanicHandler = oldHandler }()
+
+	wrote := make(chan struct{})
+	router := New(Context{})
+	router.Middleware(RequestIDMiddleware())
+	router.Timeout(10 * time.Millisecond)
+	router.Get("/stream", func(rw ResponseWriter, req *Request) {
+		rw.Write([]byte("partial"))
+		rw.Flush()
+		<-req.Context().Done()
+		rw.Write([]byte(" rest"))
+		close(wrote)
+	})
+
+	rw, req := newTestRequest("GET", "/stream")
+	req.Header.Set("X-Request-ID", "abc")
+	router.ServeHTTP(rw, req)
+	<-wrote
+	// The response is cut off:
+	assertResponse(t, rw, "partial", 200)
+	assert.Equal(t, "TIMEOUT\nURL: /stream\nREQUEST ID: abc\nROUTE: /stream\nTIMEOUT: 10ms\n", buf.String())
+}
+
+func TestTimeoutResponseDoesntRaceWithHandler(t *testing.T) {
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	router := New(Context{})
+	router.Timeout(5 * time.Millisecond)
+	router.Get("/busy", func(rw ResponseWriter, req *Request) {
+		defer close(stopped)
+		// The handler keeps looking at the response while the timeout response is sent. Run with -race.
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				rw.StatusCode()
+				rw.Written()
+				rw.Size()
+			}
+		}
+	})
+
+	rw, req := newTestRequest("GET", "/busy")
+	router.ServeHTTP(rw, req)
+	close(stop)
+	<-stopped
+	assertResponse(t, rw, "Service Unavailable", 503)
+}
+
+type TimeoutRootContext struct {
+	Step string
+}
+
+type TimeoutLeafContext struct {
+	*TimeoutRootContext
+	Handled bool
+}
+
+func TestTimeoutContextsArentShared(t *testing.T) {
+	var steps []string
+	handlerDone := make(chan struct{})
+	router := New(TimeoutRootContext{})
+	router.Middleware(func(c *TimeoutRootContext, rw ResponseWriter, req *Request, next NextMiddlewareFunc) {
+		c.Step = "before"
+		next(rw, req)
+		steps = append(steps, c.Step)
+		c.Step = "after"
+	})
+	api := router.Subrouter(TimeoutLeafContext{}, "/api")
+	api.Timeout(10 * time.Millisecond)
+	api.Error(func(c *TimeoutLeafContext, rw ResponseWriter, req *Request, err interface{}) {
+		steps = append(steps, c.Step)
+		c.Step = "error"
+		rw.WriteHeader(http.StatusGatewayTimeout)
+	})
+	api.Get("/fast", func(c *TimeoutLeafContext, rw ResponseWriter, req *Request) {
+		c.Step = "fast"
+		c.Handled = true
+	})
+	api.Get("/slow", func(c *TimeoutLeafContext, rw ResponseWriter, req *Request) {
+		defer close(handlerDone)
+		// The abandoned handler keeps using its context while the Error handler and the root middleware use
+		// theirs. Run with -race.
+		<-req.Context().Done()
+		for i := 0; i < 1000; i++ {
+			c.Step = "slow"
+			c.Handled = true
+		}
+	})
+
+	// What a handler that finishes in time does to the contexts is kept:
+	rw, req := newTestRequest("GET", "/api/fast")
+	router.ServeHTTP(rw, req)
+	assert.Equal(t, 200, rw.Code)
+	assert.Equal(t, []string{"fast"}, steps)
+
+	steps = nil
+	rw, req = newTestRequest("GET", "/api/slow")
+	router.ServeHTTP(rw, req)
+	<-handlerDone
+	assert.Equal(t, 504, rw.Code)
+	assert.Equal(t, []string{"before", "error"}, steps)
+}
+
+func TestTimeoutResponseWriterInterfaces(t *testing.T) {
+	var isPusher, isReaderFrom bool
+	var deadlineErr error
+	router := New(Context{})
+	router.Timeout(time.Minute)
+	router.Get("/", func(rw ResponseWriter, req *Request) {
+		var pusher http.Pusher
+		if pusher, isPusher = rw.(http.Pusher); isPusher {
+			pusher.Push("/app.css", nil)
+		}
+		_, isReaderFrom = rw.(io.ReaderFrom)
+		io.Copy(rw, io.LimitReader(strings.NewReader("Hello world"), 100))
+	})
+	router.Get("/deadline", func(rw ResponseWriter, req *Request) {
+		deadlineErr = http.NewResponseController(rw).SetWriteDeadline(time.Now().Add(time.Minute))
+	})
+
+	// Handlers only see an http.Pusher or an io.ReaderFrom if the underlying ResponseWriter is one:
+	rw, req := newTestRequest("GET", "/")
+	router.ServeHTTP(rw, req)
+	assert.False(t, isPusher)
+	assert.False(t, isReaderFrom)
+	assert.Equal(t, "Hello world", rw.Body.String())
+
+	pushable := &pushableRecorder{ResponseRecorder: httptest.NewRecorder()}
+	router.ServeHTTP(pushable, req)
+	assert.True(t, isPusher)
+	assert.False(t, isReaderFrom)
+	assert.Equal(t, []string{"/app.css"}, pushable.pushed)
+
+	readerFrom := &readerFromRecorder{ResponseRecorder: httptest.NewRecorder()}
+	router.ServeHTTP(readerFrom, req)
+	assert.False(t, isPusher)
+	assert.True(t, isReaderFrom)
+	assert.True(t, readerFrom.readFrom)
+	assert.Equal(t, "Hello world", readerFrom.Body.String())
+
+	// The ResponseWriter can be unwrapped by http.ResponseController:
+	server := httptest.NewServer(router)
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/deadline")
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+	}
+	assert.NoError(t, deadlineErr)
+}
@@ -0,0 +1,375 @@
+package web
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceID identifies a trace, as in the W3C Trace Context spec.
+type TraceID [16]byte
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+
+// SpanID identifies a span within a trace.
+type SpanID [8]byte
+
+func (id SpanID) String() string { return hex.EncodeToString(id[:]) }
+
+// SpanContext is the part of a span that's propagated to other services, in the traceparent and tracestate headers.
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	Sampled    bool
+	TraceState string // Passed on unchanged
+}
+
+// TraceParent returns the span context formatted as a traceparent header.
+func (sc SpanContext) TraceParent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// ParseTraceParent parses a traceparent header. It returns false if the header is missing or invalid.
+func ParseTraceParent(header string) (SpanContext, bool) {
+	var sc SpanContext
+	// version-traceid-spanid-flags, where versions after 00 may append more fields.
+	if len(header) < 55 || header[2] != '-' || header[35] != '-' || header[52] != '-' {
+		return sc, false
+	}
+	version := header[:2]
+	if version == "ff" || (version == "00" && len(header) != 55) || (len(header) > 55 && header[55] != '-') {
+		return sc, false
+	}
+	var v, flags [1]byte
+	if _, err := hex.Decode(v[:], []byte(version)); err != nil || strings.ToLower(header[:55]) != header[:55] {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(header[3:35])); err != nil || sc.TraceID == (TraceID{}) {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(header[36:52])); err != nil || sc.SpanID == (SpanID{}) {
+		return sc, false
+	}
+	if _, err := hex.Decode(flags[:], []byte(header[53:55])); err != nil {
+		return sc, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, true
+}
+
+// Span is a timed operation within a trace, such as serving a request.
+type Span struct {
+	Name         string
+	Context      SpanContext
+	ParentSpanID SpanID // Zero for the root span of a trace
+	Start        time.Time
+	End          time.Time // Zero until the span finishes
+
+	mu         sync.Mutex
+	attributes map[string]interface{}
+	exporter   SpanExporter // nil if the span isn't sampled
+}
+
+// SetAttribute records a key/value pair describing the span, eg "db.rows": 10.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.attributes == nil {
+		s.attributes = make(map[string]interface{})
+	}
+	s.attributes[key] = value
+}
+
+// Attributes returns a copy of the span's attributes.
+func (s *Span) Attributes() map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	attributes := make(map[string]interface{}, len(s.attributes))
+	for k, v := range s.attributes {
+		attributes[k] = v
+	}
+	return attributes
+}
+
+// StartChild starts a span for an operation that's part of this one. Finish it when the operation is done.
+func (s *Span) StartChild(name string) *Span {
+	child := &Span{
+		Name:         name,
+		Context:      SpanContext{TraceID: s.Context.TraceID, SpanID: newSpanID(), Sampled: s.Context.Sampled, TraceState: s.Context.TraceState},
+		ParentSpanID: s.Context.SpanID,
+		Start:        time.Now(),
+		exporter:     s.exporter,
+	}
+	return child
+}
+
+// Finish ends the span and exports it, if it's sampled.
+func (s *Span) Finish() {
+	s.End = time.Now()
+	if s.exporter != nil {
+		s.exporter.ExportSpan(s)
+	}
+}
+
+// Inject sets the traceparent and tracestate headers of an outgoing request so that the service it's sent to
+// continues this trace.
+func (s *Span) Inject(header http.Header) {
+	header.Set("traceparent", s.Context.TraceParent())
+	if s.Context.TraceState != "" {
+		header.Set("tracestate", s.Context.TraceState)
+	}
+}
+
+type spanContextKey struct{}
+
+// ContextWithSpan returns a copy of ctx that carries span.
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, span)
+}
+
+// SpanFromContext returns the span stored in ctx by TracingMiddleware (or ContextWithSpan), or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanContextKey{}).(*Span)
+	return span
+}
+
+// SpanExporter receives spans when they finish, eg to send them to a tracing backend. It must be safe to call
+// from several goroutines.
+type SpanExporter interface {
+	ExportSpan(span *Span)
+}
+
+// InMemorySpanExporter keeps the spans exported to it, eg for tests.
+type InMemorySpanExporter struct {
+	mu    sync.Mutex
+	spans []*Span
+}
+
+// ExportSpan appends span to the exported spans.
+func (e *InMemorySpanExporter) ExportSpan(span *Span) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, span)
+}
+
+// Spans returns the spans exported so far, in the order they finished.
+func (e *InMemorySpanExporter) Spans() []*Span {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]*Span(nil), e.spans...)
+}
+
+// Reset forgets the exported spans.
+func (e *InMemorySpanExporter) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = nil
+}
+
+// JSONSpanExporter writes each span as a line of JSON.
+type JSONSpanExporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewJSONSpanExporter returns an exporter that writes spans to w.
+func NewJSONSpanExporter(w io.Writer) *JSONSpanExporter {
+	return &JSONSpanExporter{w: w}
+}
+
+type jsonSpan struct {
+	Name         string                 `json:"name"`
+	TraceID      string                 `json:"trace_id"`
+	SpanID       string                 `json:"span_id"`
+	ParentSpanID string                 `json:"parent_span_id,omitempty"`
+	Start        time.Time              `json:"start"`
+	End          time.Time              `json:"end"`
+	Duration     time.Duration          `json:"duration_ns"`
+	Attributes   map[string]interface{} `json:"attributes,omitempty"`
+}
+
+// ExportSpan writes span to the exporter's writer.
+func (e *JSONSpanExporter) ExportSpan(span *Span) {
+	js := jsonSpan{
+		Name:       span.Name,
+		TraceID:    span.Context.TraceID.String(),
+		SpanID:     span.Context.SpanID.String(),
+		Start:      span.Start,
+		End:        span.End,
+		Duration:   span.End.Sub(span.Start),
+		Attributes: span.Attributes(),
+	}
+	if span.ParentSpanID != (SpanID{}) {
+		js.ParentSpanID = span.ParentSpanID.String()
+	}
+	line, err := json.Marshal(js)
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.w.Write(line)
+}
+
+// TracingOption configures TracingMiddleware.
+type TracingOption struct {
+	// Exporter receives the spans of sampled traces. If it's nil, spans are created and propagated, but not exported.
+	Exporter SpanExporter
+
+	// If set, every middleware after TracingMiddleware, and the handler, gets a child span, named after its function.
+	// Each one is nested in the span of the middleware that called it.
+	MiddlewareSpans bool
+}
+
+// TracingMiddleware returns a middleware that traces requests, following the W3C Trace Context spec. Each request
+// gets a server span, which continues the trace in the request's traceparent header if there is one (keeping its
+// sampling decision), or starts a new, sampled trace. The span is named after the method and route (eg,
+// "GET /users/:id"), and is stored in the request's context, where SpanFromContext finds it. Add the middleware
+// before others so that it times them too.
+func TracingMiddleware(options ...TracingOption) func(ResponseWriter, *Request, NextMiddlewareFunc) {
+	var option TracingOption
+	if len(options) > 0 {
+		option = options[0]
+	}
+
+	return func(rw ResponseWriter, req *Request, next NextMiddlewareFunc) {
+		span := &Span{Name: req.Method, Start: time.Now()}
+		if parent, ok := ParseTraceParent(req.Header.Get("traceparent")); ok {
+			span.Context = parent
+			span.ParentSpanID = parent.SpanID
+			span.Context.TraceState = req.Header.Get("tracestate")
+		} else {
+			span.Context.TraceID = newTraceID()
+			span.Context.Sampled = true
+		}
+		span.Context.SpanID = newSpanID()
+		if span.Context.Sampled {
+			span.exporter = option.Exporter
+		}
+		span.SetAttribute("http.request.method", req.Method)
+		span.SetAttribute("url.path", req.URL.Path)
+
+		req.Request = req.Request.WithContext(ContextWithSpan(req.Context(), span))
+		if option.MiddlewareSpans {
+			req.traceSpan = span
+		}
+
+		panicked := true
+		defer func() {
+			req.traceSpan = nil
+			if !panicked {
+				finishRequestSpan(span, req, responseStatus(rw, false, nil), false)
+				return
+			}
+			// Finish the span once the router's error handling has answered the panic. If the response had
+			// started, the panic cut it off, whatever its status.
+			written, status := rw.Written(), rw.StatusCode()
+			req.afterPanic(func(err interface{}) {
+				if written {
+					finishRequestSpan(span, req, status, true)
+				} else {
+					finishRequestSpan(span, req, responseStatus(rw, true, err), false)
+				}
+			})
+		}()
+		next(rw, req)
+		panicked = false
+	}
+}
+
+func finishRequestSpan(span *Span, req *Request, status int, failed bool) {
+	if route := req.RoutePath(); route != "" {
+		span.Name = req.Method + " " + route
+		span.SetAttribute("http.route", route)
+	}
+	span.SetAttribute("http.response.status_code", status)
+	if failed || status >= http.StatusInternalServerError {
+		span.SetAttribute("error", true)
+	}
+	span.Finish()
+}
+
+// traceStep runs fn, a middleware or handler called name, in a child of the request's current span.
+func traceStep(req *Request, name string, fn func()) {
+	parent := req.traceSpan
+	span := parent.StartChild(name)
+	req.traceSpan = span
+	panicked := true
+	defer func() {
+		req.traceSpan = parent
+		if !panicked {
+			span.Finish()
+			return
+		}
+		// Panics that are answered with a client error (eg, a *BindError) aren't errors of the span.
+		req.afterPanic(func(err interface{}) {
+			if panicStatus(err) >= http.StatusInternalServerError {
+				span.SetAttribute("error", true)
+			}
+			span.Finish()
+		})
+	}()
+	fn()
+	panicked = false
+}
+
+func (mw *middlewareHandler) name() string {
+	if mw.Generic {
+		return funcName(reflect.ValueOf(mw.GenericMiddleware))
+	}
+	return funcName(mw.DynamicMiddleware)
+}
+
+func (ah *actionHandler) name() string {
+	if ah.Generic {
+		return funcName(reflect.ValueOf(ah.GenericHandler))
+	}
+	return funcName(ah.DynamicHandler)
+}
+
+// funcName returns the name of a function without its package path, eg "web.(*Context).A".
+func funcName(fn reflect.Value) string {
+	f := runtime.FuncForPC(fn.Pointer())
+	if f == nil {
+		return "unknown"
+	}
+	name := f.Name()
+	if i := strings.LastIndexByte(name, '/'); i >= 0 {
+		name = name[i+1:]
+	}
+	return strings.TrimSuffix(name, "-fm")
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	for id == (TraceID{}) {
+		if _, err := rand.Read(id[:]); err != nil {
+			panic(err)
+		}
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for id == (SpanID{}) {
+		if _, err := rand.Read(id[:]); err != nil {
+			panic(err)
+		}
+	}
+	return id
+}
@@ -0,0 +1,182 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func (c *Context) SpanAction(w ResponseWriter, r *Request) {
+	span := SpanFromContext(r.Context())
+	span.SetAttribute("user.id", r.PathParams["id"])
+	header := http.Header{}
+	span.Inject(header)
+	w.Write([]byte(header.Get("traceparent")))
+}
+
+func TestTraceParent(t *testing.T) {
+	sc, ok := ParseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	assert.True(t, ok)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID.String())
+	assert.Equal(t, "00f067aa0ba902b7", sc.SpanID.String())
+	assert.True(t, sc.Sampled)
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", sc.TraceParent())
+
+	sc, ok = ParseTraceParent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-future")
+	assert.True(t, ok)
+	assert.False(t, sc.Sampled)
+
+	for _, header := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473x-00f067aa0ba902b7-01",
+		"00_4bf92f3577b34da6a3ce929d0e0e4736_00f067aa0ba902b7_01",
+	} {
+		_, ok := ParseTraceParent(header)
+		assert.False(t, ok, header)
+	}
+}
+
+func TestTracingMiddleware(t *testing.T) {
+	exporter := &InMemorySpanExporter{}
+	router := New(Context{})
+	router.Middleware(TracingMiddleware(TracingOption{Exporter: exporter}))
+	router.Get("/users/:id", (*Context).SpanAction)
+	router.Get("/boom", (*Context).ErrorAction)
+	router.Get("/invalid", func(rw ResponseWriter, req *Request) { panic(&BindError{}) })
+
+	// A new trace:
+	rw, req := newTestRequest("GET", "/users/3")
+	router.ServeHTTP(rw, req)
+	spans := exporter.Spans()
+	assert.Equal(t, 1, len(spans))
+	span := spans[0]
+	assert.Equal(t, "GET /users/:id", span.Name)
+	assert.Equal(t, SpanID{}, span.ParentSpanID)
+	assert.True(t, span.Context.Sampled)
+	assert.Equal(t, span.Context.TraceParent(), rw.Body.String())
+	assert.False(t, span.End.Before(span.Start))
+	assert.Equal(t, map[string]interface{}{
+		"http.request.method":       "GET",
+		"url.path":                  "/users/3",
+		"http.route":                "/users/:id",
+		"http.response.status_code": 200,
+		"user.id":                   "3",
+	}, span.Attributes())
+
+	// Continuing a trace:
+	exporter.Reset()
+	rw, req = newTestRequest("GET", "/users/3")
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req.Header.Set("tracestate", "vendor=abc")
+	router.ServeHTTP(rw, req)
+	span = exporter.Spans()[0]
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.Context.TraceID.String())
+	assert.Equal(t, "00f067aa0ba902b7", span.ParentSpanID.String())
+	assert.NotEqual(t, span.ParentSpanID, span.Context.SpanID)
+	assert.Equal(t, "vendor=abc", span.Context.TraceState)
+
+	// Unsampled traces are propagated but not exported:
+	exporter.Reset()
+	rw, req = newTestRequest("GET", "/users/3")
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	router.ServeHTTP(rw, req)
+	assert.Equal(t, 0, len(exporter.Spans()))
+	assert.Regexp(t, "^00-4bf92f3577b34da6a3ce929d0e0e4736-[0-9a-f]{16}-00$", rw.Body.String())
+
+	// Errors and unrouted requests:
+	exporter.Reset()
+	rw, req = newTestRequest("GET", "/boom")
+	router.ServeHTTP(rw, req)
+	rw, req = newTestRequest("GET", "/missing")
+	router.ServeHTTP(rw, req)
+	rw, req = newTestRequest("GET", "/invalid")
+	router.ServeHTTP(rw, req)
+	spans = exporter.Spans()
+	assert.Equal(t, 3, len(spans))
+	assert.Equal(t, "GET /boom", spans[0].Name)
+	assert.Equal(t, 500, spans[0].Attributes()["http.response.status_code"])
+	assert.Equal(t, true, spans[0].Attributes()["error"])
+	assert.Equal(t, "GET", spans[1].Name)
+	assert.Equal(t, 404, spans[1].Attributes()["http.response.status_code"])
+	// Panics with a StatusCoder below 500 are client errors:
+	assert.Equal(t, 422, spans[2].Attributes()["http.response.status_code"])
+	assert.Nil(t, spans[2].Attributes()["error"])
+}
+
+func TestTracingMiddlewareSpans(t *testing.T) {
+	exporter := &InMemorySpanExporter{}
+	router := New(Context{})
+	router.Middleware(TracingMiddleware(TracingOption{Exporter: exporter, MiddlewareSpans: true}))
+	router.Middleware((*Context).mwAlpha)
+	admin := router.Subrouter(AdminContext{}, "/admin")
+	admin.Middleware((*AdminContext).mwEpsilon)
+	admin.Get("/action", (*AdminContext).B)
+
+	rw, req := newTestRequest("GET", "/admin/action")
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "context-mw-Alpha admin-mw-Epsilon admin-B", 200)
+
+	// Spans finish innermost first:
+	spans := exporter.Spans()
+	assert.Equal(t, 4, len(spans))
+	names := make([]string, len(spans))
+	for i, span := range spans {
+		names[i] = span.Name
+	}
+	assert.Equal(t, []string{"web.(*AdminContext).B", "web.(*AdminContext).mwEpsilon", "web.(*Context).mwAlpha", "GET /admin/action"}, names)
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, spans[i+1].Context.SpanID, spans[i].ParentSpanID)
+		assert.Equal(t, spans[3].Context.TraceID, spans[i].Context.TraceID)
+	}
+
+	// Panics mark the spans they pass through:
+	exporter.Reset()
+	admin.Get("/boom", (*AdminContext).ErrorAction)
+	rw, req = newTestRequest("GET", "/admin/boom")
+	router.ServeHTTP(rw, req)
+	for _, span := range exporter.Spans() {
+		assert.Equal(t, true, span.Attributes()["error"], span.Name)
+	}
+
+	// But not client errors:
+	exporter.Reset()
+	admin.Get("/invalid", func(rw ResponseWriter, req *Request) { panic(&BindError{}) })
+	rw, req = newTestRequest("GET", "/admin/invalid")
+	router.ServeHTTP(rw, req)
+	spans = exporter.Spans()
+	assert.Equal(t, 4, len(spans))
+	assert.Equal(t, "GET /admin/invalid", spans[3].Name)
+	for _, span := range spans[:3] {
+		assert.Nil(t, span.Attributes()["error"], span.Name)
+	}
+}
+
+func TestJSONSpanExporter(t *testing.T) {
+	var buf bytes.Buffer
+	router := New(Context{})
+	router.Middleware(TracingMiddleware(TracingOption{Exporter: NewJSONSpanExporter(&buf), MiddlewareSpans: true}))
+	router.Get("/action", (*Context).A)
+
+	rw, req := newTestRequest("GET", "/action")
+	router.ServeHTTP(rw, req)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal(t, 2, len(lines))
+	var handler, server map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &handler))
+	assert.NoError(t, json.Unmarshal([]byte(lines[1]), &server))
+	assert.Equal(t, "web.(*Context).A", handler["name"])
+	assert.Equal(t, "GET /action", server["name"])
+	assert.Equal(t, server["span_id"], handler["parent_span_id"])
+	assert.Equal(t, server["trace_id"], handler["trace_id"])
+	assert.NotContains(t, server, "parent_span_id")
+	assert.Equal(t, float64(200), server["attributes"].(map[string]interface{})["http.response.status_code"])
+}
@@ -0,0 +1,329 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// ValidatorFunc checks a single struct field. param is whatever followed the '=' in the rule, eg "3" for
+// "min=3", or "" if there was none. Return nil if the value is valid, or an error whose message describes
+// the problem (eg "must be a valid email address").
+type ValidatorFunc func(value reflect.Value, param string) error
+
+// ValidationFieldError describes a field that failed one of its validate rules.
+type ValidationFieldError struct {
+	// Path is a JSON pointer (RFC 6901) to the field, built from json tag names. Eg "/address/zip" or "/items/2/sku".
+	Path    string `json:"path"`
+	Rule    string `json:"rule"`
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+}
+
+func (e *ValidationFieldError) Error() string {
+	return e.Path + " " + e.Message
+}
+
+// ValidationError is returned by Validate. It lists every field that failed validation.
+type ValidationError struct {
+	Fields []*ValidationFieldError `json:"errors"`
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		msgs[i] = f.Error()
+	}
+	return "web: validation failed: " + strings.Join(msgs, "; ")
+}
+
+// StatusCode returns 422 Unprocessable Entity. See StatusCoder.
+func (e *ValidationError) StatusCode() int {
+	return http.StatusUnprocessableEntity
+}
+
+var validators = struct {
+	sync.RWMutex
+	m map[string]ValidatorFunc
+}{m: map[string]ValidatorFunc{
+	"min":   validateMin,
+	"max":   validateMax,
+	"len":   validateLen,
+	"oneof": validateOneOf,
+	"email": validateEmail,
+	"url":   validateURL,
+}}
+
+// RegisterValidator makes fn available as the rule name in validate tags. Registering an existing name
+// (including a built-in one) replaces it. "required" and "omitempty" are reserved.
+func RegisterValidator(name string, fn ValidatorFunc) {
+	if name == "required" || name == "omitempty" {
+		panic("web: can't register a validator named " + name)
+	}
+	validators.Lock()
+	defer validators.Unlock()
+	validators.m[name] = fn
+}
+
+// Validate checks the struct pointed to by v (or v itself) against its validate tags, descending into nested
+// structs, slices and maps. Rules are comma separated:
+//
+//	type Signup struct {
+//		Email string   `json:"email" validate:"required,email"`
+//		Age   int      `json:"age" validate:"min=13,max=130"`
+//		Plan  string   `json:"plan" validate:"omitempty,oneof=free pro"`
+//		Tags  []string `json:"tags" validate:"max=5"`
+//	}
+//
+// Built-in rules are required, omitempty (skip the rest of the rules if the value is zero), min, max, len
+// (a number's value, or the length of a string, slice or map), oneof (space separated), email and url.
+// Add your own with RegisterValidator. If any field fails, the returned error is a *ValidationError.
+func Validate(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			panic("web: Validate needs a struct or a non-nil pointer to one")
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		panic("web: Validate needs a struct or a non-nil pointer to one")
+	}
+
+	var verr ValidationError
+	validateStruct(rv, "", &verr)
+	if len(verr.Fields) > 0 {
+		return &verr
+	}
+	return nil
+}
+
+// BindAndValidate binds the request into dst (see Bind), then validates it (see Validate).
+// It returns either a *BindError or a *ValidationError, both of which are StatusCoders.
+func (r *Request) BindAndValidate(dst interface{}) error {
+	if err := r.Bind(dst); err != nil {
+		return err
+	}
+	return Validate(dst)
+}
+
+type validateRule struct {
+	name  string
+	param string
+}
+
+type validateField struct {
+	index     int
+	pointer   string // escaped JSON pointer token
+	required  bool
+	omitEmpty bool
+	rules     []validateRule
+}
+
+var validateFieldCache sync.Map // map[reflect.Type][]*validateField
+
+func cachedValidateFields(t reflect.Type) []*validateField {
+	if fields, ok := validateFieldCache.Load(t); ok {
+		return fields.([]*validateField)
+	}
+
+	var fields []*validateField
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" && !sf.Anonymous {
+			continue
+		}
+		f := &validateField{index: i, pointer: jsonPointerToken(jsonFieldName(sf))}
+		if sf.Anonymous {
+			f.pointer = ""
+		}
+		for _, rule := range strings.Split(sf.Tag.Get("validate"), ",") {
+			rule = strings.TrimSpace(rule)
+			switch {
+			case rule == "":
+			case rule == "required":
+				f.required = true
+			case rule == "omitempty":
+				f.omitEmpty = true
+			default:
+				name, param := rule, ""
+				if idx := strings.IndexByte(rule, '='); idx >= 0 {
+					name, param = rule[:idx], rule[idx+1:]
+				}
+				f.rules = append(f.rules, validateRule{name: name, param: param})
+			}
+		}
+		fields = append(fields, f)
+	}
+
+	validateFieldCache.Store(t, fields)
+	return fields
+}
+
+func validateStruct(v reflect.Value, path string, verr *ValidationError) {
+	for _, f := range cachedValidateFields(v.Type()) {
+		fieldPath := path
+		if f.pointer != "" {
+			fieldPath += "/" + f.pointer
+		}
+		validateValue(v.Field(f.index), f, fieldPath, verr)
+	}
+}
+
+func validateValue(v reflect.Value, f *validateField, path string, verr *ValidationError) {
+	if v.IsZero() {
+		if f.required {
+			verr.Fields = append(verr.Fields, &ValidationFieldError{Path: path, Rule: "required", Message: "is required"})
+			return
+		}
+		if f.omitEmpty || v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+			return
+		}
+	}
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			// Eg, a **T holding a nil *T, or an interface holding a nil pointer: as empty as a nil *T.
+			if f.required {
+				verr.Fields = append(verr.Fields, &ValidationFieldError{Path: path, Rule: "required", Message: "is required"})
+			}
+			return
+		}
+		v = v.Elem()
+	}
+
+	for _, rule := range f.rules {
+		validators.RLock()
+		fn, ok := validators.m[rule.name]
+		validators.RUnlock()
+		if !ok {
+			panic("web: unknown validate rule " + strconv.Quote(rule.name))
+		}
+		if err := fn(v, rule.param); err != nil {
+			verr.Fields = append(verr.Fields, &ValidationFieldError{Path: path, Rule: rule.name, Param: rule.param, Message: err.Error()})
+			return
+		}
+	}
+
+	validateNested(v, path, verr)
+}
+
+// validateNested descends into structs and the elements of slices, arrays and maps.
+func validateNested(v reflect.Value, path string, verr *ValidationError) {
+	switch v.Kind() {
+	case reflect.Struct:
+		if v.Type() != timeType {
+			validateStruct(v, path, verr)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			validateElem(v.Index(i), path+"/"+strconv.Itoa(i), verr)
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			validateElem(iter.Value(), path+"/"+jsonPointerToken(fmt.Sprint(iter.Key().Interface())), verr)
+		}
+	}
+}
+
+func validateElem(v reflect.Value, path string, verr *ValidationError) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+	validateNested(v, path, verr)
+}
+
+func jsonFieldName(sf reflect.StructField) string {
+	if tag := sf.Tag.Get("json"); tag != "" && tag != "-" {
+		if name := strings.Split(tag, ",")[0]; name != "" {
+			return name
+		}
+	}
+	return sf.Name
+}
+
+func jsonPointerToken(s string) string {
+	return strings.Replace(strings.Replace(s, "~", "~0", -1), "/", "~1", -1)
+}
+
+// validateSize returns the number the min/max/len rules compare against: a number's value, or a length.
+func validateSize(v reflect.Value) (float64, bool, error) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), false, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), false, nil
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), false, nil
+	case reflect.String:
+		return float64(utf8.RuneCountInString(v.String())), true, nil
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return float64(v.Len()), true, nil
+	}
+	return 0, false, fmt.Errorf("can't be compared (type %s)", v.Type())
+}
+
+func compareSize(v reflect.Value, param string, ok func(size, limit float64) bool, numberMsg, lengthMsg string) error {
+	limit, err := strconv.ParseFloat(param, 64)
+	if err != nil {
+		panic("web: invalid validate param " + strconv.Quote(param))
+	}
+	size, isLength, err := validateSize(v)
+	if err != nil {
+		return err
+	}
+	if ok(size, limit) {
+		return nil
+	}
+	if isLength {
+		return fmt.Errorf(lengthMsg, param)
+	}
+	return fmt.Errorf(numberMsg, param)
+}
+
+func validateMin(v reflect.Value, param string) error {
+	return compareSize(v, param, func(size, limit float64) bool { return size >= limit }, "must be at least %s", "must have a length of at least %s")
+}
+
+func validateMax(v reflect.Value, param string) error {
+	return compareSize(v, param, func(size, limit float64) bool { return size <= limit }, "must be at most %s", "must have a length of at most %s")
+}
+
+func validateLen(v reflect.Value, param string) error {
+	return compareSize(v, param, func(size, limit float64) bool { return size == limit }, "must be %s", "must have a length of %s")
+}
+
+func validateOneOf(v reflect.Value, param string) error {
+	s := fmt.Sprint(v.Interface())
+	for _, option := range strings.Fields(param) {
+		if s == option {
+			return nil
+		}
+	}
+	return fmt.Errorf("must be one of [%s]", param)
+}
+
+func validateEmail(v reflect.Value, param string) error {
+	addr, err := mail.ParseAddress(v.String())
+	if v.Kind() != reflect.String || err != nil || addr.Address != v.String() {
+		return fmt.Errorf("must be a valid email address")
+	}
+	return nil
+}
+
+func validateURL(v reflect.Value, param string) error {
+	u, err := url.Parse(v.String())
+	if v.Kind() != reflect.String || err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("must be a valid URL")
+	}
+	return nil
+}
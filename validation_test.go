@@ -0,0 +1,135 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type validateAddress struct {
+	Zip string `json:"zip" validate:"required,len=5"`
+}
+
+type validateItem struct {
+	SKU string `json:"sku" validate:"required"`
+}
+
+type validateSignup struct {
+	Email     string          `json:"email" validate:"required,email"`
+	Age       int             `json:"age" validate:"min=13,max=130"`
+	Plan      string          `json:"plan" validate:"omitempty,oneof=free pro"`
+	Website   *string         `json:"website" validate:"url"`
+	Nickname  string          `json:"nick/name" validate:"max=3"`
+	Address   validateAddress `json:"address"`
+	Items     []*validateItem `json:"items" validate:"min=1"`
+	Referrals int             `query:"referrals" validate:"even"`
+}
+
+func init() {
+	RegisterValidator("even", func(v reflect.Value, param string) error {
+		if v.Int()%2 != 0 {
+			return fmt.Errorf("must be even")
+		}
+		return nil
+	})
+}
+
+func TestValidateValid(t *testing.T) {
+	website := "https://example.com"
+	signup := validateSignup{
+		Email:   "a@example.com",
+		Age:     30,
+		Website: &website,
+		Address: validateAddress{Zip: "94107"},
+		Items:   []*validateItem{{SKU: "x"}},
+	}
+	assert.NoError(t, Validate(&signup))
+
+	signup.Plan = "pro"
+	signup.Website = nil
+	assert.NoError(t, Validate(signup))
+}
+
+func TestValidateErrors(t *testing.T) {
+	website := "not a url"
+	signup := validateSignup{
+		Email:     "nope",
+		Age:       7,
+		Plan:      "enterprise",
+		Website:   &website,
+		Nickname:  "gopher",
+		Items:     []*validateItem{{SKU: "x"}, {}},
+		Referrals: 3,
+	}
+	err := Validate(&signup)
+	verr, ok := err.(*ValidationError)
+	if !assert.True(t, ok) {
+		return
+	}
+	assert.Equal(t, http.StatusUnprocessableEntity, verr.StatusCode())
+
+	var got []string
+	for _, f := range verr.Fields {
+		got = append(got, f.Path+" "+f.Rule)
+	}
+	assert.Equal(t, []string{
+		"/email email",
+		"/age min",
+		"/plan oneof",
+		"/website url",
+		"/nick~1name max",
+		"/address/zip required",
+		"/items/1/sku required",
+		"/Referrals even",
+	}, got)
+	assert.Equal(t, "must have a length of at most 3", verr.Fields[4].Message)
+
+	b, _ := json.Marshal(verr)
+	assert.Contains(t, string(b), `{"path":"/age","rule":"min","param":"13","message":"must be at least 13"}`)
+}
+
+func TestValidateNilInsidePointers(t *testing.T) {
+	type nested struct {
+		Name   **string    `validate:"required,min=1"`
+		Any    interface{} `validate:"required"`
+		Avatar **string    `validate:"url"`
+	}
+	var name, avatar *string
+	var anyPtr *int
+	err := Validate(&nested{Name: &name, Any: anyPtr, Avatar: &avatar})
+	verr, ok := err.(*ValidationError)
+	if !assert.True(t, ok) {
+		return
+	}
+	var got []string
+	for _, f := range verr.Fields {
+		got = append(got, f.Path+" "+f.Rule)
+	}
+	assert.Equal(t, []string{"/Name required", "/Any required"}, got)
+}
+
+func TestBindAndValidate(t *testing.T) {
+	router := New(Context{})
+	router.Post("/signup", func(rw ResponseWriter, req *Request) {
+		var signup validateSignup
+		if err := req.BindAndValidate(&signup); err != nil {
+			panic(err)
+		}
+		fmt.Fprint(rw, "ok")
+	})
+
+	req, _ := http.NewRequest("POST", "/signup", strings.NewReader(`{"email": "a@example.com", "age": 20, "address": {"zip": "12345"}, "items": [{"sku": "a"}]}`))
+	rw := httptest.NewRecorder()
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "ok", 200)
+
+	req, _ = http.NewRequest("POST", "/signup?referrals=1", strings.NewReader(`{"email": "a@example.com", "age": 20, "address": {"zip": "12345"}, "items": [{"sku": "a"}]}`))
+	rw = httptest.NewRecorder()
+	router.ServeHTTP(rw, req)
+	assertResponse(t, rw, "web: validation failed: /Referrals must be even", http.StatusUnprocessableEntity)
+}
@@ -0,0 +1,628 @@
+package web
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Message types, as used by WebSocketConn's ReadMessage and WriteMessage. They're the RFC 6455 opcodes.
+const (
+	TextMessage   = 1
+	BinaryMessage = 2
+	CloseMessage  = 8
+	PingMessage   = 9
+	PongMessage   = 10
+)
+
+// Close codes defined by RFC 6455, section 7.4.1.
+const (
+	CloseNormalClosure     = 1000
+	CloseGoingAway         = 1001
+	CloseProtocolError     = 1002
+	CloseUnsupportedData   = 1003
+	CloseNoStatusReceived  = 1005
+	CloseAbnormalClosure   = 1006
+	CloseInvalidPayload    = 1007
+	ClosePolicyViolation   = 1008
+	CloseMessageTooBig     = 1009
+	CloseInternalServerErr = 1011
+)
+
+const (
+	websocketAcceptGUID     = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	websocketDeflateTrailer = "\x00\x00\xff\xff"
+)
+
+// WebSocketOptions configures Upgrade. Every field is optional.
+type WebSocketOptions struct {
+	// CheckOrigin returns whether to accept the request's Origin. By default, requests with an Origin header
+	// are only accepted if its host matches the request's Host.
+	CheckOrigin func(req *Request) bool
+
+	// Subprotocols are the subprotocols the server supports, most preferred first. The first one the
+	// client also asked for (in Sec-WebSocket-Protocol) is selected.
+	Subprotocols []string
+
+	// ReadLimit is the maximum size in bytes of a message read from the client. Larger messages close the
+	// connection with CloseMessageTooBig. Defaults to DefaultWebSocketReadLimit.
+	ReadLimit int64
+
+	// FragmentSize splits written messages into frames of at most this many bytes. 0 means don't split.
+	FragmentSize int
+
+	// EnableCompression negotiates the permessage-deflate extension (RFC 7692) if the client supports it.
+	EnableCompression bool
+
+	// CloseTimeout is how long Close waits for the client to acknowledge a close frame. Defaults to 5 seconds.
+	CloseTimeout time.Duration
+}
+
+// DefaultWebSocketReadLimit is the maximum size of a message read from a client unless WebSocketOptions.ReadLimit is set.
+var DefaultWebSocketReadLimit int64 = 1 << 20
+
+// CloseError is returned by ReadMessage once the connection has been closed. Code is the close code sent by
+// the client, or 1006 if the connection was dropped without a close frame.
+type CloseError struct {
+	Code int
+	Text string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("web: websocket closed: %d %s", e.Code, e.Text)
+}
+
+// HandshakeError is returned by Upgrade when the request isn't a valid WebSocket handshake. Upgrade has already
+// responded with Status.
+type HandshakeError struct {
+	Status  int
+	Message string
+}
+
+func (e *HandshakeError) Error() string {
+	return "web: websocket handshake failed: " + e.Message
+}
+
+// WebSocketConn is a WebSocket connection. One goroutine may read (ReadMessage) while others write: writes are
+// serialized. The default ping handler answers pings with pongs.
+type WebSocketConn struct {
+	conn         net.Conn
+	br           *bufio.Reader
+	bw           *bufio.Writer
+	subprotocol  string
+	compress     bool
+	readLimit    int64
+	fragmentSize int
+	closeTimeout time.Duration
+
+	pingHandler func(data string) error
+	pongHandler func(data string) error
+
+	writeMu       sync.Mutex
+	closeSent     bool
+	closeReceived bool
+	readErr       error
+}
+
+// Upgrade performs the WebSocket opening handshake and takes over the connection. Register the handler that
+// calls it like any other route, so that your middleware (authentication, logging and so on) runs first:
+//
+//	router.Get("/socket", func(rw web.ResponseWriter, req *web.Request) {
+//		conn, err := web.Upgrade(rw, req)
+//		if err != nil {
+//			return // Upgrade has already responded.
+//		}
+//		defer conn.Close()
+//		for {
+//			msgType, msg, err := conn.ReadMessage()
+//			if err != nil {
+//				return
+//			}
+//			conn.WriteMessage(msgType, msg)
+//		}
+//	})
+//
+// If the handshake fails, Upgrade responds with an appropriate error status and returns a *HandshakeError.
+func Upgrade(rw ResponseWriter, req *Request, options ...WebSocketOptions) (*WebSocketConn, error) {
+	var option WebSocketOptions
+	if len(options) > 0 {
+		option = options[0]
+	}
+
+	fail := func(status int, msg string) (*WebSocketConn, error) {
+		if status == http.StatusUpgradeRequired {
+			rw.Header().Set("Sec-WebSocket-Version", "13")
+		}
+		http.Error(rw, http.StatusText(status), status)
+		return nil, &HandshakeError{Status: status, Message: msg}
+	}
+
+	if req.Method != "GET" {
+		return fail(http.StatusMethodNotAllowed, "method must be GET")
+	}
+	if !headerContainsToken(req.Header, "Connection", "upgrade") || !headerContainsToken(req.Header, "Upgrade", "websocket") {
+		return fail(http.StatusBadRequest, "missing Connection: Upgrade and Upgrade: websocket headers")
+	}
+	if req.Header.Get("Sec-WebSocket-Version") != "13" {
+		return fail(http.StatusUpgradeRequired, "unsupported Sec-WebSocket-Version")
+	}
+	key := req.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		return fail(http.StatusBadRequest, "invalid Sec-WebSocket-Key")
+	}
+	checkOrigin := option.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = sameOrigin
+	}
+	if !checkOrigin(req) {
+		return fail(http.StatusForbidden, "origin not allowed")
+	}
+
+	ws := &WebSocketConn{
+		readLimit:    option.ReadLimit,
+		fragmentSize: option.FragmentSize,
+		closeTimeout: option.CloseTimeout,
+	}
+	if ws.readLimit <= 0 {
+		ws.readLimit = DefaultWebSocketReadLimit
+	}
+	if ws.closeTimeout <= 0 {
+		ws.closeTimeout = 5 * time.Second
+	}
+	ws.pingHandler = func(data string) error {
+		err := ws.WriteControl(PongMessage, []byte(data))
+		if _, ok := err.(*CloseError); ok {
+			return nil // We've started closing, so there's no need to answer.
+		}
+		return err
+	}
+	ws.subprotocol = selectSubprotocol(req, option.Subprotocols)
+	ws.compress = option.EnableCompression && clientSupportsDeflate(req)
+
+	conn, brw, err := rw.Hijack()
+	if err != nil {
+		return fail(http.StatusInternalServerError, err.Error())
+	}
+	// Clear any deadlines left from the server's ReadTimeout and WriteTimeout (not every server clears them on
+	// hijack), or the connection would be dropped when they pass.
+	conn.SetDeadline(time.Time{})
+	if w := unwrapAppResponseWriter(rw); w != nil {
+		w.statusCode = http.StatusSwitchingProtocols
+	}
+	ws.conn, ws.br, ws.bw = conn, brw.Reader, brw.Writer
+
+	accept := sha1.Sum([]byte(key + websocketAcceptGUID))
+	var resp bytes.Buffer
+	resp.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+	resp.WriteString("Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(accept[:]) + "\r\n")
+	if ws.subprotocol != "" {
+		resp.WriteString("Sec-WebSocket-Protocol: " + ws.subprotocol + "\r\n")
+	}
+	if ws.compress {
+		// Without context takeover each message is compressed on its own, so nothing is kept between messages.
+		resp.WriteString("Sec-WebSocket-Extensions: permessage-deflate; server_no_context_takeover; client_no_context_takeover\r\n")
+	}
+	resp.WriteString("\r\n")
+	if _, err := ws.bw.Write(resp.Bytes()); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := ws.bw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ws, nil
+}
+
+// Subprotocol returns the negotiated subprotocol, or "" if none was.
+func (c *WebSocketConn) Subprotocol() string {
+	return c.subprotocol
+}
+
+// SetPingHandler sets the function called with the payload of each ping received. The default handler responds with a pong.
+func (c *WebSocketConn) SetPingHandler(fn func(data string) error) {
+	c.pingHandler = fn
+}
+
+// SetPongHandler sets the function called with the payload of each pong received. By default, pongs are ignored.
+func (c *WebSocketConn) SetPongHandler(fn func(data string) error) {
+	c.pongHandler = fn
+}
+
+// SetReadDeadline sets the deadline for reads from the underlying connection. See net.Conn.
+func (c *WebSocketConn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the deadline for writes to the underlying connection. See net.Conn.
+func (c *WebSocketConn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+// RemoteAddr returns the client's network address.
+func (c *WebSocketConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// ReadMessage reads the next text or binary message, reassembling fragmented messages and handling control
+// frames along the way. Once the client closes the connection (or violates the protocol), it returns a *CloseError.
+func (c *WebSocketConn) ReadMessage() (messageType int, data []byte, err error) {
+	if c.readErr != nil {
+		return 0, nil, c.readErr
+	}
+	messageType, data, err = c.readMessage()
+	if err != nil {
+		c.readErr = err
+	}
+	return messageType, data, err
+}
+
+func (c *WebSocketConn) readMessage() (int, []byte, error) {
+	var message []byte
+	messageType := 0
+	compressed := false
+
+	for {
+		f, err := c.readFrame()
+		if err != nil {
+			if ce, ok := err.(*CloseError); ok {
+				c.WriteClose(ce.Code, "")
+				return 0, nil, err
+			}
+			return 0, nil, &CloseError{Code: CloseAbnormalClosure, Text: err.Error()}
+		}
+
+		switch f.opcode {
+		case PingMessage, PongMessage, CloseMessage:
+			if err := c.handleControl(f); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case 0:
+			if messageType == 0 {
+				return 0, nil, c.protocolError(CloseProtocolError, "unexpected continuation frame")
+			}
+		case TextMessage, BinaryMessage:
+			if messageType != 0 {
+				return 0, nil, c.protocolError(CloseProtocolError, "expected a continuation frame")
+			}
+			messageType = f.opcode
+			compressed = f.rsv1
+		default:
+			return 0, nil, c.protocolError(CloseProtocolError, "unknown opcode")
+		}
+
+		if int64(len(message))+int64(len(f.payload)) > c.readLimit {
+			return 0, nil, c.protocolError(CloseMessageTooBig, "message too big")
+		}
+		message = append(message, f.payload...)
+		if !f.fin {
+			continue
+		}
+
+		if compressed {
+			message, err = c.inflate(message)
+			if err != nil {
+				return 0, nil, err
+			}
+		}
+		if messageType == TextMessage && !utf8.Valid(message) {
+			return 0, nil, c.protocolError(CloseInvalidPayload, "invalid UTF-8")
+		}
+		return messageType, message, nil
+	}
+}
+
+func (c *WebSocketConn) handleControl(f *websocketFrame) error {
+	switch f.opcode {
+	case PingMessage:
+		if c.pingHandler != nil {
+			return c.pingHandler(string(f.payload))
+		}
+	case PongMessage:
+		if c.pongHandler != nil {
+			return c.pongHandler(string(f.payload))
+		}
+	case CloseMessage:
+		c.closeReceived = true
+		ce := &CloseError{Code: CloseNoStatusReceived}
+		switch {
+		case len(f.payload) == 1:
+			return c.protocolError(CloseProtocolError, "invalid close frame")
+		case len(f.payload) >= 2:
+			ce.Code = int(binary.BigEndian.Uint16(f.payload))
+			ce.Text = string(f.payload[2:])
+			if !validCloseCode(ce.Code) || !utf8.ValidString(ce.Text) {
+				return c.protocolError(CloseProtocolError, "invalid close frame")
+			}
+		}
+		echo := ce.Code
+		if echo == CloseNoStatusReceived {
+			echo = CloseNormalClosure
+		}
+		c.WriteClose(echo, "")
+		return ce
+	}
+	return nil
+}
+
+// protocolError closes the connection with code and returns the corresponding error.
+func (c *WebSocketConn) protocolError(code int, text string) error {
+	c.WriteClose(code, text)
+	return &CloseError{Code: code, Text: text}
+}
+
+func (c *WebSocketConn) inflate(data []byte) ([]byte, error) {
+	fr := flate.NewReader(io.MultiReader(bytes.NewReader(data), strings.NewReader(websocketDeflateTrailer)))
+	defer fr.Close()
+	inflated, err := ioutil.ReadAll(io.LimitReader(fr, c.readLimit+1))
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, c.protocolError(CloseInvalidPayload, "invalid compressed data")
+	}
+	if int64(len(inflated)) > c.readLimit {
+		return nil, c.protocolError(CloseMessageTooBig, "message too big")
+	}
+	return inflated, nil
+}
+
+type websocketFrame struct {
+	fin     bool
+	rsv1    bool
+	opcode  int
+	payload []byte
+}
+
+func (c *WebSocketConn) readFrame() (*websocketFrame, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.br, header[:]); err != nil {
+		return nil, err
+	}
+
+	f := &websocketFrame{
+		fin:    header[0]&0x80 != 0,
+		rsv1:   header[0]&0x40 != 0,
+		opcode: int(header[0] & 0x0f),
+	}
+	if header[0]&0x30 != 0 || (f.rsv1 && (!c.compress || f.opcode == 0 || f.opcode >= CloseMessage)) {
+		return nil, c.protocolError(CloseProtocolError, "unexpected reserved bits")
+	}
+	if header[1]&0x80 == 0 {
+		return nil, c.protocolError(CloseProtocolError, "client frames must be masked")
+	}
+
+	length := int64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return nil, err
+		}
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return nil, err
+		}
+		length = int64(binary.BigEndian.Uint64(ext[:]))
+	}
+
+	if f.opcode >= CloseMessage && (length > 125 || !f.fin) {
+		return nil, c.protocolError(CloseProtocolError, "invalid control frame")
+	}
+	if length < 0 || length > c.readLimit {
+		return nil, c.protocolError(CloseMessageTooBig, "message too big")
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+		return nil, err
+	}
+	f.payload = make([]byte, length)
+	if _, err := io.ReadFull(c.br, f.payload); err != nil {
+		return nil, err
+	}
+	for i := range f.payload {
+		f.payload[i] ^= mask[i%4]
+	}
+	return f, nil
+}
+
+// WriteMessage writes a text or binary message. It's safe to call concurrently with other writes.
+func (c *WebSocketConn) WriteMessage(messageType int, data []byte) error {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		return errors.New("web: WriteMessage needs a TextMessage or BinaryMessage")
+	}
+
+	compressed := false
+	if c.compress {
+		var buf bytes.Buffer
+		fw, _ := flate.NewWriter(&buf, flate.BestSpeed)
+		fw.Write(data)
+		fw.Flush()
+		data = bytes.TrimSuffix(buf.Bytes(), []byte(websocketDeflateTrailer))
+		compressed = true
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closeSent {
+		return &CloseError{Code: CloseNormalClosure, Text: "close already sent"}
+	}
+
+	opcode := messageType
+	for {
+		chunk := data
+		if c.fragmentSize > 0 && len(chunk) > c.fragmentSize {
+			chunk = data[:c.fragmentSize]
+		}
+		data = data[len(chunk):]
+		if err := c.writeFrame(len(data) == 0, compressed, opcode, chunk); err != nil {
+			return err
+		}
+		if len(data) == 0 {
+			return c.bw.Flush()
+		}
+		opcode, compressed = 0, false
+	}
+}
+
+// WriteControl writes a ping or pong frame with an optional payload of at most 125 bytes.
+func (c *WebSocketConn) WriteControl(messageType int, data []byte) error {
+	if (messageType != PingMessage && messageType != PongMessage) || len(data) > 125 {
+		return errors.New("web: WriteControl needs a PingMessage or PongMessage of at most 125 bytes")
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closeSent {
+		return &CloseError{Code: CloseNormalClosure, Text: "close already sent"}
+	}
+	if err := c.writeFrame(true, false, messageType, data); err != nil {
+		return err
+	}
+	return c.bw.Flush()
+}
+
+// WritePing sends a ping. The client answers with a pong, which is passed to the pong handler.
+func (c *WebSocketConn) WritePing(data []byte) error {
+	return c.WriteControl(PingMessage, data)
+}
+
+// WriteClose starts the closing handshake by sending a close frame. It's a no-op if one was already sent.
+// No messages can be written afterwards, but ReadMessage keeps working until the client's close frame arrives.
+func (c *WebSocketConn) WriteClose(code int, text string) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closeSent {
+		return nil
+	}
+	c.closeSent = true
+
+	payload := make([]byte, 2, 2+len(text))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	payload = append(payload, text...)
+	if len(payload) > 125 {
+		payload = payload[:125]
+	}
+	if err := c.writeFrame(true, false, CloseMessage, payload); err != nil {
+		return err
+	}
+	return c.bw.Flush()
+}
+
+// Close performs the closing handshake, if it hasn't happened yet, and closes the connection. It sends a normal
+// close frame unless WriteClose was already called, then waits up to CloseTimeout for the client's close frame.
+// Don't call Close while another goroutine is in ReadMessage; use WriteClose to end the connection from there.
+func (c *WebSocketConn) Close() error {
+	c.WriteClose(CloseNormalClosure, "")
+	if !c.closeReceived && c.readErr == nil {
+		c.conn.SetReadDeadline(time.Now().Add(c.closeTimeout))
+		for {
+			if _, _, err := c.ReadMessage(); err != nil {
+				break
+			}
+		}
+	}
+	return c.conn.Close()
+}
+
+func (c *WebSocketConn) writeFrame(fin, rsv1 bool, opcode int, payload []byte) error {
+	var header [10]byte
+	header[0] = byte(opcode)
+	if fin {
+		header[0] |= 0x80
+	}
+	if rsv1 {
+		header[0] |= 0x40
+	}
+
+	n := 2
+	switch length := len(payload); {
+	case length <= 125:
+		header[1] = byte(length)
+	case length <= 0xffff:
+		header[1] = 126
+		binary.BigEndian.PutUint16(header[2:], uint16(length))
+		n += 2
+	default:
+		header[1] = 127
+		binary.BigEndian.PutUint64(header[2:], uint64(length))
+		n += 8
+	}
+
+	if _, err := c.bw.Write(header[:n]); err != nil {
+		return err
+	}
+	_, err := c.bw.Write(payload)
+	return err
+}
+
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1011, code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
+
+// headerContainsToken returns whether the comma separated header name contains token, case-insensitively.
+func headerContainsToken(header http.Header, name, token string) bool {
+	for _, value := range header[http.CanonicalHeaderKey(name)] {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func sameOrigin(req *Request) bool {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, req.Host)
+}
+
+func selectSubprotocol(req *Request, supported []string) string {
+	var requested []string
+	for _, value := range req.Header["Sec-Websocket-Protocol"] {
+		for _, p := range strings.Split(value, ",") {
+			requested = append(requested, strings.TrimSpace(p))
+		}
+	}
+	for _, s := range supported {
+		for _, r := range requested {
+			if s == r {
+				return s
+			}
+		}
+	}
+	return ""
+}
+
+func clientSupportsDeflate(req *Request) bool {
+	for _, value := range req.Header["Sec-Websocket-Extensions"] {
+		for _, ext := range strings.Split(value, ",") {
+			if strings.TrimSpace(strings.Split(ext, ";")[0]) == "permessage-deflate" {
+				return true
+			}
+		}
+	}
+	return false
+}
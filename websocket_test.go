@@ -0,0 +1,307 @@
+package web
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testWebSocketClient is a minimal client that speaks just enough RFC 6455 to exercise the server.
+type testWebSocketClient struct {
+	conn   net.Conn
+	br     *bufio.Reader
+	header http.Header
+	status int
+}
+
+func dialTestWebSocket(t *testing.T, server *httptest.Server, path string, headers map[string]string) *testWebSocketClient {
+	conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	req := "GET " + path + " HTTP/1.1\r\nHost: " + strings.TrimPrefix(server.URL, "http://") + "\r\n" +
+		"Upgrade: websocket\r\nConnection: keep-alive, Upgrade\r\nSec-WebSocket-Version: 13\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"
+	for k, v := range headers {
+		req += k + ": " + v + "\r\n"
+	}
+	if _, err := io.WriteString(conn, req+"\r\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &testWebSocketClient{conn: conn, br: bufio.NewReader(conn)}
+	resp, err := http.ReadResponse(c.br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.header, c.status = resp.Header, resp.StatusCode
+	return c
+}
+
+func (c *testWebSocketClient) writeFrame(fin, rsv1 bool, opcode int, payload []byte) {
+	b0 := byte(opcode)
+	if fin {
+		b0 |= 0x80
+	}
+	if rsv1 {
+		b0 |= 0x40
+	}
+	frame := []byte{b0}
+	switch {
+	case len(payload) <= 125:
+		frame = append(frame, 0x80|byte(len(payload)))
+	default:
+		frame = append(frame, 0x80|126, byte(len(payload)>>8), byte(len(payload)))
+	}
+	mask := []byte{1, 2, 3, 4}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	c.conn.Write(frame)
+}
+
+func (c *testWebSocketClient) readFrame(t *testing.T) (fin, rsv1 bool, opcode int, payload []byte) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.br, header[:]); err != nil {
+		t.Fatal(err)
+	}
+	length := int(header[1] & 0x7f)
+	if length == 126 {
+		var ext [2]byte
+		io.ReadFull(c.br, ext[:])
+		length = int(binary.BigEndian.Uint16(ext[:]))
+	}
+	payload = make([]byte, length)
+	io.ReadFull(c.br, payload)
+	return header[0]&0x80 != 0, header[0]&0x40 != 0, int(header[0] & 0x0f), payload
+}
+
+func (c *testWebSocketClient) closeFrame(code int) []byte {
+	payload := make([]byte, 2)
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	return payload
+}
+
+func newWebSocketServer(options WebSocketOptions, middlewareRan *bool) *httptest.Server {
+	return httptest.NewServer(newWebSocketRouter(options, middlewareRan))
+}
+
+func newWebSocketRouter(options WebSocketOptions, middlewareRan *bool) *Router {
+	router := New(Context{})
+	router.Middleware(func(rw ResponseWriter, req *Request, next NextMiddlewareFunc) {
+		*middlewareRan = true
+		next(rw, req)
+	})
+	router.Get("/echo", func(rw ResponseWriter, req *Request) {
+		conn, err := Upgrade(rw, req, options)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if conn.Subprotocol() != "" {
+			conn.WriteMessage(TextMessage, []byte("protocol "+conn.Subprotocol()))
+		}
+		for {
+			msgType, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			conn.WriteMessage(msgType, msg)
+		}
+	})
+	return router
+}
+
+func TestWebSocketEcho(t *testing.T) {
+	var middlewareRan bool
+	server := newWebSocketServer(WebSocketOptions{FragmentSize: 4}, &middlewareRan)
+	defer server.Close()
+
+	client := dialTestWebSocket(t, server, "/echo", nil)
+	assert.True(t, middlewareRan)
+	assert.Equal(t, http.StatusSwitchingProtocols, client.status)
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", client.header.Get("Sec-WebSocket-Accept"))
+	assert.Equal(t, "", client.header.Get("Sec-WebSocket-Extensions"))
+
+	// A fragmented message with a ping in the middle:
+	client.writeFrame(false, false, TextMessage, []byte("hel"))
+	client.writeFrame(true, false, PingMessage, []byte("are you there"))
+	client.writeFrame(true, false, 0, []byte("lo!!!"))
+
+	_, _, opcode, payload := client.readFrame(t)
+	assert.Equal(t, PongMessage, opcode)
+	assert.Equal(t, "are you there", string(payload))
+
+	// The echo is written in frames of 4 bytes:
+	var frames []string
+	var message []byte
+	for {
+		fin, _, opcode, payload := client.readFrame(t)
+		frames = append(frames, fmt.Sprintf("%d:%s", opcode, payload))
+		message = append(message, payload...)
+		if fin {
+			break
+		}
+	}
+	assert.Equal(t, []string{"1:hell", "0:o!!!"}, frames)
+	assert.Equal(t, "hello!!!", string(message))
+
+	// Close handshake:
+	client.writeFrame(true, false, CloseMessage, client.closeFrame(CloseGoingAway))
+	_, _, opcode, payload = client.readFrame(t)
+	assert.Equal(t, CloseMessage, opcode)
+	assert.Equal(t, CloseGoingAway, int(binary.BigEndian.Uint16(payload)))
+	_, err := client.br.ReadByte()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestWebSocketOutlivesServerTimeouts(t *testing.T) {
+	var middlewareRan bool
+	server := httptest.NewUnstartedServer(newWebSocketRouter(WebSocketOptions{}, &middlewareRan))
+	server.Config.ReadTimeout = 20 * time.Millisecond
+	server.Config.WriteTimeout = 20 * time.Millisecond
+	server.Start()
+	defer server.Close()
+
+	client := dialTestWebSocket(t, server, "/echo", nil)
+	assert.Equal(t, http.StatusSwitchingProtocols, client.status)
+
+	time.Sleep(50 * time.Millisecond)
+	client.writeFrame(true, false, TextMessage, []byte("still there?"))
+	_, _, opcode, payload := client.readFrame(t)
+	assert.Equal(t, TextMessage, opcode)
+	assert.Equal(t, "still there?", string(payload))
+}
+
+func TestWebSocketOutlivesRouterTimeout(t *testing.T) {
+	recorder := &timeoutRecorder{}
+	oldHandler := PanicHandler
+	PanicHandler = recorder
+	defer func() { PanicHandler = oldHandler }()
+
+	router := New(Context{})
+	router.Timeout(20 * time.Millisecond)
+	router.Get("/context", func(rw ResponseWriter, req *Request) {
+		conn, err := Upgrade(rw, req)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		time.Sleep(50 * time.Millisecond)
+		_, hasDeadline := req.Context().Deadline()
+		conn.WriteMessage(TextMessage, []byte(fmt.Sprint(req.Context().Err(), " ", hasDeadline)))
+	})
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	// Once the connection is hijacked, the timeout doesn't apply:
+	client := dialTestWebSocket(t, server, "/context", nil)
+	assert.Equal(t, http.StatusSwitchingProtocols, client.status)
+	_, _, opcode, payload := client.readFrame(t)
+	assert.Equal(t, TextMessage, opcode)
+	assert.Equal(t, "<nil> false", string(payload))
+
+	recorder.mu.Lock()
+	assert.Empty(t, recorder.routes)
+	recorder.mu.Unlock()
+}
+
+func TestWebSocketProtocolErrors(t *testing.T) {
+	var middlewareRan bool
+	server := newWebSocketServer(WebSocketOptions{ReadLimit: 10}, &middlewareRan)
+	defer server.Close()
+
+	for _, tc := range []struct {
+		name   string
+		frames func(c *testWebSocketClient)
+		code   int
+	}{
+		{"too big", func(c *testWebSocketClient) { c.writeFrame(true, false, BinaryMessage, make([]byte, 11)) }, CloseMessageTooBig},
+		{"bad utf-8", func(c *testWebSocketClient) { c.writeFrame(true, false, TextMessage, []byte{0xff}) }, CloseInvalidPayload},
+		{"stray continuation", func(c *testWebSocketClient) { c.writeFrame(true, false, 0, []byte("x")) }, CloseProtocolError},
+		{"unnegotiated compression", func(c *testWebSocketClient) { c.writeFrame(true, true, TextMessage, []byte("x")) }, CloseProtocolError},
+		{"unmasked", func(c *testWebSocketClient) { c.conn.Write([]byte{0x81, 0x01, 'x'}) }, CloseProtocolError},
+	} {
+		client := dialTestWebSocket(t, server, "/echo", nil)
+		tc.frames(client)
+		_, _, opcode, payload := client.readFrame(t)
+		assert.Equal(t, CloseMessage, opcode, tc.name)
+		assert.Equal(t, tc.code, int(binary.BigEndian.Uint16(payload)), tc.name)
+		client.conn.Close()
+	}
+}
+
+func TestWebSocketHandshake(t *testing.T) {
+	var middlewareRan bool
+	server := newWebSocketServer(WebSocketOptions{Subprotocols: []string{"v2", "v1"}}, &middlewareRan)
+	defer server.Close()
+
+	client := dialTestWebSocket(t, server, "/echo", map[string]string{"Sec-WebSocket-Protocol": "v1, v2"})
+	assert.Equal(t, "v2", client.header.Get("Sec-WebSocket-Protocol"))
+	_, _, _, payload := client.readFrame(t)
+	assert.Equal(t, "protocol v2", string(payload))
+	client.conn.Close()
+
+	// Cross-origin requests are refused by default:
+	client = dialTestWebSocket(t, server, "/echo", map[string]string{"Origin": "http://evil.example.com"})
+	assert.Equal(t, http.StatusForbidden, client.status)
+	client.conn.Close()
+
+	client = dialTestWebSocket(t, server, "/echo", map[string]string{"Origin": server.URL})
+	assert.Equal(t, http.StatusSwitchingProtocols, client.status)
+	client.conn.Close()
+
+	// Plain HTTP requests and unknown versions are refused:
+	resp, err := http.Get(server.URL + "/echo")
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		resp.Body.Close()
+	}
+
+	rw, req := newTestRequest("GET", "/echo")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "8")
+	_, err = Upgrade(&appResponseWriter{ResponseWriter: rw}, &Request{Request: req})
+	assert.Equal(t, http.StatusUpgradeRequired, err.(*HandshakeError).Status)
+	assert.Equal(t, "13", rw.Header().Get("Sec-WebSocket-Version"))
+}
+
+func TestWebSocketCompression(t *testing.T) {
+	var middlewareRan bool
+	server := newWebSocketServer(WebSocketOptions{EnableCompression: true}, &middlewareRan)
+	defer server.Close()
+
+	client := dialTestWebSocket(t, server, "/echo", map[string]string{"Sec-WebSocket-Extensions": "permessage-deflate; client_max_window_bits"})
+	assert.Contains(t, client.header.Get("Sec-WebSocket-Extensions"), "permessage-deflate")
+
+	message := strings.Repeat("compress me ", 20)
+	var buf bytes.Buffer
+	fw, _ := flate.NewWriter(&buf, flate.BestCompression)
+	fw.Write([]byte(message))
+	fw.Flush()
+	client.writeFrame(true, true, TextMessage, bytes.TrimSuffix(buf.Bytes(), []byte{0, 0, 0xff, 0xff}))
+
+	fin, rsv1, opcode, payload := client.readFrame(t)
+	assert.True(t, fin)
+	assert.True(t, rsv1)
+	assert.Equal(t, TextMessage, opcode)
+	assert.True(t, len(payload) < len(message))
+	inflated, _ := ioutil.ReadAll(flate.NewReader(io.MultiReader(bytes.NewReader(payload), strings.NewReader("\x00\x00\xff\xff"))))
+	assert.Equal(t, message, string(inflated))
+	client.conn.Close()
+}